@@ -0,0 +1,577 @@
+package docker_deploy
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrBundleManifestMissing = errors.New("bundle does not start with a manifest.json")
+var ErrBundleHasNoImages = errors.New("bundle does not contain any images")
+var ErrBundleImageMismatch = errors.New("bundle image does not match the image id in its manifest")
+var ErrBundlePathOutsideBundles = errors.New("'path' must be a relative path in the bundles directory")
+var ErrInvalidBundleName = errors.New("'name' must be a file name without a directory")
+
+const bundleManifestVersion = 1
+const bundleManifestName = "manifest.json"
+const bundleImagesName = "images.tar"
+const bundleVolumesDir = "volumes"
+
+// A bundle is a single tar file holding a manifest, the output of `docker save` for the component's pinned images
+// and one archive per named volume. Bundles are written to and read from VIAM_MODULE_DATA/bundles, so a bundle
+// copied there on another robot is used instead of pulling the images.
+type BundleManifest struct {
+	Version   int            `json:"version"`
+	Component string         `json:"component"`
+	CreatedAt time.Time      `json:"created_at"`
+	Images    []BundleImage  `json:"images"`
+	Volumes   []BundleVolume `json:"volumes"`
+	Files     []BundleFile   `json:"files"`
+}
+
+type BundleImage struct {
+	PinnedImage
+	ImageID string `json:"image_id"`
+}
+
+type BundleVolume struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+type BundleFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (m *BundleManifest) containsImage(image PinnedImage) bool {
+	for _, i := range m.Images {
+		if i.RepoDigest == image.RepoDigest {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *BundleManifest) file(path string) *BundleFile {
+	for i := range m.Files {
+		if m.Files[i].Path == path {
+			return &m.Files[i]
+		}
+	}
+	return nil
+}
+
+func bundleDirectory() (string, error) {
+	moduleDirectory, err := moduleDataDirectory()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(moduleDirectory, "bundles")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Images loaded from a bundle have no repo digest, only an offline tag that anything could carry. The module records
+// the image id each digest was loaded as, and only trusts an offline tag pointing at that image.
+const loadedImagesFileName = "loaded_images.json"
+
+var loadedImagesMu sync.Mutex
+
+// loadedImages returns the image id each digest was loaded from a bundle as
+func loadedImages() (map[string]string, error) {
+	loadedImagesMu.Lock()
+	defer loadedImagesMu.Unlock()
+	return readLoadedImages()
+}
+
+// readLoadedImages reads the record, loadedImagesMu must be held
+func readLoadedImages() (map[string]string, error) {
+	loaded := map[string]string{}
+	dir, err := moduleDataDirectory()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, loadedImagesFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return loaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// updateLoadedImages records the image ids of digests loaded from a bundle, an empty id forgets the digest
+func updateLoadedImages(update map[string]string) error {
+	loadedImagesMu.Lock()
+	defer loadedImagesMu.Unlock()
+	loaded, err := readLoadedImages()
+	if err != nil {
+		return err
+	}
+	for digest, imageId := range update {
+		if imageId == "" {
+			delete(loaded, digest)
+		} else {
+			loaded[digest] = imageId
+		}
+	}
+	data, err := json.Marshal(loaded)
+	if err != nil {
+		return err
+	}
+	dir, err := moduleDataDirectory()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, loadedImagesFileName), data, 0644)
+}
+
+// loadedFromBundle reports whether the module loaded the digest from a bundle rather than pulling it
+func loadedFromBundle(digest string) bool {
+	loaded, err := loadedImages()
+	return err == nil && loaded[digest] != ""
+}
+
+// trustedOfflineRepoDigest is repoDigestFromOfflineTags for an image, it returns "repository@digest" only if the
+// module loaded the image with that id from a bundle
+func trustedOfflineRepoDigest(tags []string, imageId string, loaded map[string]string) string {
+	repoDigest := repoDigestFromOfflineTags(tags)
+	_, digest, ok := strings.Cut(repoDigest, "@")
+	if !ok || imageId == "" || loaded[digest] != imageId {
+		return ""
+	}
+	return repoDigest
+}
+
+// pinnedImages returns the image from the config along with any other digest pinned images in the compose file
+func pinnedImages(conf *Config) ([]PinnedImage, error) {
+	images := []PinnedImage{{ImageName: conf.ImageName, RepoDigest: conf.RepoDigest}}
+	if conf.ComposeOptions == nil {
+		return images, nil
+	}
+
+	project, err := loadComposeProject(conf.ImageName, conf.ComposeOptions.ComposeFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range project.Services {
+		s := strings.Split(service.Image, "@")
+		if len(s) != 2 {
+			continue
+		}
+		image := PinnedImage{ImageName: s[0], RepoDigest: s[1]}
+		if !containsPinnedImage(images, image) {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// unpinnedComposeServices returns the services of the compose file whose image isn't pinned by digest
+func unpinnedComposeServices(conf *Config) ([]string, error) {
+	if conf.ComposeOptions == nil {
+		return nil, nil
+	}
+	project, err := loadComposeProject(conf.ImageName, conf.ComposeOptions.ComposeFile)
+	if err != nil {
+		return nil, err
+	}
+	var unpinned []string
+	for _, service := range project.Services {
+		if !strings.Contains(service.Image, "@") {
+			unpinned = append(unpinned, service.Name)
+		}
+	}
+	sort.Strings(unpinned)
+	return unpinned, nil
+}
+
+func containsPinnedImage(images []PinnedImage, image PinnedImage) bool {
+	for _, i := range images {
+		if i.RepoDigest == image.RepoDigest {
+			return true
+		}
+	}
+	return false
+}
+
+// namedVolumes returns the named (not host path) volumes used by the config
+func namedVolumes(conf *Config) ([]string, error) {
+	var volumes []string
+	add := func(name string) {
+		if name == "" || strings.HasPrefix(name, "/") || strings.HasPrefix(name, ".") {
+			return
+		}
+		for _, v := range volumes {
+			if v == name {
+				return
+			}
+		}
+		volumes = append(volumes, name)
+	}
+
+	if conf.RunOptions != nil {
+		for _, v := range conf.RunOptions.Volumes {
+			add(v.Name)
+		}
+		if binds, ok := conf.RunOptions.HostOptions["Binds"].(string); ok {
+			for _, bind := range strings.Split(binds, ",") {
+				add(strings.Split(bind, ":")[0])
+			}
+		}
+	}
+
+	if conf.ComposeOptions != nil {
+		project, err := loadComposeProject(conf.ImageName, conf.ComposeOptions.ComposeFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range project.Services {
+			for _, v := range service.Volumes {
+				if v.Type == "volume" {
+					add(v.Source)
+				}
+			}
+		}
+	}
+	return volumes, nil
+}
+
+// exportBundle saves the pinned images and named volumes of the component into a single bundle and returns its path
+func (dc *DockerConfig) exportBundle(ctx context.Context, conf *Config, name string) (string, *BundleManifest, error) {
+	if name != "" && (!filepath.IsLocal(name) || filepath.Base(name) != name) {
+		return "", nil, fmt.Errorf("%w: %s", ErrInvalidBundleName, name)
+	}
+	images, err := pinnedImages(conf)
+	if err != nil {
+		return "", nil, err
+	}
+	volumes, err := namedVolumes(conf)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := bundleDirectory()
+	if err != nil {
+		return "", nil, err
+	}
+	staging, err := os.MkdirTemp(dir, ".staging-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest := &BundleManifest{
+		Version:   bundleManifestVersion,
+		Component: dc.Name().ShortName(),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	details, err := dc.manager.ListImages()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, image := range images {
+		imageId := ""
+		for _, d := range details {
+			if d.RepoDigest == image.RepoDigest {
+				imageId = d.ImageID
+			}
+		}
+		if imageId == "" {
+			return "", nil, fmt.Errorf("image %s: %w", image, ErrImageNotFound)
+		}
+		manifest.Images = append(manifest.Images, BundleImage{PinnedImage: image, ImageID: imageId})
+	}
+
+	dc.logger.Infof("Saving %d image(s) to bundle", len(images))
+	file, err := writeBundleFile(staging, bundleImagesName, func(w io.Writer) error {
+		return dc.manager.SaveImages(ctx, images, w)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	manifest.Files = append(manifest.Files, *file)
+
+	for _, volume := range volumes {
+		dc.logger.Infof("Archiving volume %s to bundle", volume)
+		volumePath := filepath.ToSlash(filepath.Join(bundleVolumesDir, volume+".tar"))
+		file, err := writeBundleFile(staging, volumePath, func(w io.Writer) error {
+			return dc.manager.ExportVolume(ctx, volume, images[0], w)
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		manifest.Files = append(manifest.Files, *file)
+		manifest.Volumes = append(manifest.Volumes, BundleVolume{Name: volume, File: volumePath})
+	}
+
+	if name == "" {
+		name = manifest.Component
+	}
+	bundlePath := filepath.Join(dir, fmt.Sprintf("%s-%s.tar", name, manifest.CreatedAt.Format("20060102T150405Z")))
+	if err := assembleBundle(bundlePath, staging, manifest); err != nil {
+		os.Remove(bundlePath)
+		return "", nil, err
+	}
+	dc.logger.Infof("Bundle written to %s", bundlePath)
+	return bundlePath, manifest, nil
+}
+
+// writeBundleFile writes a single bundle entry into the staging directory, recording its size and checksum
+func writeBundleFile(staging string, path string, write func(w io.Writer) error) (*BundleFile, error) {
+	fullPath := filepath.Join(staging, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	if err := write(io.MultiWriter(f, hash, counter)); err != nil {
+		return nil, err
+	}
+	return &BundleFile{Path: path, Size: counter.n, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// assembleBundle writes the manifest followed by every staged file into a single tar
+func assembleBundle(bundlePath string, staging string, manifest *BundleManifest) error {
+	out, err := os.OpenFile(bundlePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	tw := tar.NewWriter(out)
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: bundleManifestName, Mode: 0600, Size: int64(len(manifestBytes)), ModTime: manifest.CreatedAt}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifestBytes); err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		f, err := os.Open(filepath.Join(staging, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{Name: file.Path, Mode: 0600, Size: file.Size, ModTime: manifest.CreatedAt})
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readBundleManifest reads only the manifest, which is always the first entry of a bundle
+func readBundleManifest(bundlePath string) (*BundleManifest, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Name != bundleManifestName {
+		return nil, ErrBundleManifestMissing
+	}
+	var manifest BundleManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// verifyBundle checks every file in the bundle against the size and checksum recorded in the manifest
+func verifyBundle(bundlePath string, manifest *BundleManifest) error {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	seen := map[string]bool{}
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Name == bundleManifestName {
+			continue
+		}
+		expected := manifest.file(header.Name)
+		if expected == nil {
+			return fmt.Errorf("bundle entry %s is not listed in the manifest", header.Name)
+		}
+		hash := sha256.New()
+		n, err := io.Copy(hash, tr)
+		if err != nil {
+			return err
+		}
+		if n != expected.Size || hex.EncodeToString(hash.Sum(nil)) != expected.SHA256 {
+			return fmt.Errorf("bundle entry %s does not match its checksum", header.Name)
+		}
+		seen[header.Name] = true
+	}
+	for _, file := range manifest.Files {
+		if !seen[file.Path] {
+			return fmt.Errorf("bundle entry %s is missing", file.Path)
+		}
+	}
+	return nil
+}
+
+// importBundle verifies a bundle, loads its images and, if asked to, restores its volumes
+func (dc *DockerConfig) importBundle(ctx context.Context, bundlePath string, restoreVolumes bool) (*BundleManifest, error) {
+	manifest, err := readBundleManifest(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Images) == 0 {
+		return nil, ErrBundleHasNoImages
+	}
+	if err := verifyBundle(bundlePath, manifest); err != nil {
+		return nil, err
+	}
+
+	volumeFiles := map[string]string{}
+	for _, volume := range manifest.Volumes {
+		volumeFiles[volume.File] = volume.Name
+	}
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == bundleImagesName {
+			dc.logger.Infof("Loading %d image(s) from bundle %s", len(manifest.Images), bundlePath)
+			if err := dc.manager.LoadImages(ctx, tr); err != nil {
+				return nil, err
+			}
+			// The checksums only prove the bundle is intact, the loaded images must also be the ones it was made from
+			loaded := map[string]string{}
+			for _, image := range manifest.Images {
+				imageId, err := dc.manager.OfflineImageID(ctx, image.PinnedImage)
+				if err != nil {
+					return nil, err
+				}
+				if imageId != image.ImageID {
+					return nil, fmt.Errorf("image %s loaded as %s instead of %s: %w", image.PinnedImage, imageId, image.ImageID, ErrBundleImageMismatch)
+				}
+				loaded[image.RepoDigest] = imageId
+			}
+			if err := updateLoadedImages(loaded); err != nil {
+				return nil, err
+			}
+			for _, image := range manifest.Images {
+				dc.recordPulledImages(image.RepoDigest)
+			}
+		} else if volume, ok := volumeFiles[header.Name]; ok && restoreVolumes {
+			// images.tar is always written first, so the helper image is loaded by now
+			dc.logger.Infof("Restoring volume %s from bundle %s", volume, bundlePath)
+			if err := dc.manager.ImportVolume(ctx, volume, manifest.Images[0].PinnedImage, tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// loadImageFromBundles looks for a bundle containing the image and loads it, so robots without network access
+// can be seeded by copying a bundle into VIAM_MODULE_DATA/bundles.
+func (dc *DockerConfig) loadImageFromBundles(ctx context.Context, image PinnedImage) (bool, error) {
+	dir, err := bundleDirectory()
+	if err != nil {
+		return false, err
+	}
+	bundles, err := filepath.Glob(filepath.Join(dir, "*.tar"))
+	if err != nil {
+		return false, err
+	}
+	for _, bundlePath := range bundles {
+		manifest, err := readBundleManifest(bundlePath)
+		if err != nil {
+			dc.logger.Warnf("Skipping bundle %s: %v", bundlePath, err)
+			continue
+		}
+		if !manifest.containsImage(image) {
+			continue
+		}
+		if _, err := dc.importBundle(ctx, bundlePath, false); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// resolveBundlePath resolves a bundle path from a DoCommand relative to the bundle directory, which it can't leave
+func resolveBundlePath(path string) (string, error) {
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("%w: %s", ErrBundlePathOutsideBundles, path)
+	}
+	dir, err := bundleDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, path), nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
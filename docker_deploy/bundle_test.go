@@ -0,0 +1,162 @@
+package docker_deploy
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	staging := t.TempDir()
+	images, err := writeBundleFile(staging, bundleImagesName, func(w io.Writer) error {
+		_, err := w.Write([]byte("images"))
+		return err
+	})
+	assert.NoError(t, err)
+	volume, err := writeBundleFile(staging, "volumes/data.tar", func(w io.Writer) error {
+		_, err := w.Write([]byte("volume"))
+		return err
+	})
+	assert.NoError(t, err)
+
+	manifest := &BundleManifest{
+		Version:   bundleManifestVersion,
+		Component: "container0",
+		CreatedAt: time.Now().UTC(),
+		Images:    []BundleImage{{PinnedImage: PinnedImage{ImageName: "ubuntu", RepoDigest: "sha256:abc"}, ImageID: "sha256:def"}},
+		Volumes:   []BundleVolume{{Name: "data", File: volume.Path}},
+		Files:     []BundleFile{*images, *volume},
+	}
+	bundlePath := filepath.Join(t.TempDir(), "bundle.tar")
+	assert.NoError(t, assembleBundle(bundlePath, staging, manifest))
+
+	read, err := readBundleManifest(bundlePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "container0", read.Component)
+	assert.True(t, read.containsImage(PinnedImage{ImageName: "ubuntu", RepoDigest: "sha256:abc"}))
+	assert.NoError(t, verifyBundle(bundlePath, read))
+
+	// A tampered checksum must be detected
+	read.Files[1].SHA256 = "00"
+	assert.Error(t, verifyBundle(bundlePath, read))
+}
+
+// writeTestBundle writes a bundle into the bundle directory whose manifest claims the image has imageId, while
+// loading its images.tar gives loadedId
+func writeTestBundle(t *testing.T, name string, image PinnedImage, imageId string, loadedId string) string {
+	dir, err := bundleDirectory()
+	assert.NoError(t, err)
+	staging := t.TempDir()
+	images, err := writeBundleFile(staging, bundleImagesName, func(w io.Writer) error {
+		_, err := io.WriteString(w, `{"`+image.RepoDigest+`": "`+loadedId+`"}`)
+		return err
+	})
+	assert.NoError(t, err)
+	manifest := &BundleManifest{
+		Version:   bundleManifestVersion,
+		Component: "container0",
+		CreatedAt: time.Now().UTC(),
+		Images:    []BundleImage{{PinnedImage: image, ImageID: imageId}},
+		Files:     []BundleFile{*images},
+	}
+	bundlePath := filepath.Join(dir, name)
+	assert.NoError(t, assembleBundle(bundlePath, staging, manifest))
+	return bundlePath
+}
+
+func TestBundleImportChecksImageIds(t *testing.T) {
+	t.Setenv("VIAM_MODULE_DATA", t.TempDir())
+	image := PinnedImage{ImageName: "ubuntu", RepoDigest: "sha256:abc"}
+
+	// images.tar was swapped for another image and the checksums recomputed
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	tampered := writeTestBundle(t, "tampered.tar", image, "sha256:good", "sha256:evil")
+	_, err := dc.importBundle(context.Background(), tampered, false)
+	assert.ErrorIs(t, err, ErrBundleImageMismatch)
+	assert.False(t, loadedFromBundle(image.RepoDigest))
+
+	manager = newFakeDockerManager()
+	dc = newTestDockerConfig(t, manager)
+	intact := writeTestBundle(t, "intact.tar", image, "sha256:good", "sha256:good")
+	_, err = dc.importBundle(context.Background(), intact, false)
+	assert.NoError(t, err)
+	loaded, err := loadedImages()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"sha256:abc": "sha256:good"}, loaded)
+
+	// Only the image the module loaded is known by its offline tag
+	tags := []string{"ubuntu:sha256-abc"}
+	assert.Equal(t, "ubuntu@sha256:abc", trustedOfflineRepoDigest(tags, "sha256:good", loaded))
+	assert.Equal(t, "", trustedOfflineRepoDigest(tags, "sha256:evil", loaded))
+	assert.Empty(t, DiskUsageImage{ID: "sha256:evil", RepoTags: tags}.repoDigests(loaded))
+}
+
+func TestEnforcedVerifyPullsBundleImages(t *testing.T) {
+	t.Setenv("VIAM_MODULE_DATA", t.TempDir())
+	image := PinnedImage{ImageName: "ubuntu", RepoDigest: "sha256:abc"}
+	writeTestBundle(t, "bundle.tar", image, "sha256:good", "sha256:good")
+	_, publicKey := newCosignKey(t)
+	conf := validRunConfig()
+	conf.Verify = &VerifyOptions{PublicKeys: []string{publicKey}, Enforce: true}
+
+	// The bundle isn't used
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	assert.NoError(t, dc.ensureImage(context.Background(), image, conf))
+	assert.Equal(t, 1, manager.pulls)
+	assert.Nil(t, manager.offlineImages)
+
+	// An image loaded from it earlier is pulled again, and can't pass verification until it is
+	manager = newFakeDockerManager()
+	dc = newTestDockerConfig(t, manager)
+	assert.NoError(t, updateLoadedImages(map[string]string{image.RepoDigest: "sha256:good"}))
+	manager.images[image.RepoDigest] = true
+	err := dc.verifySignatures(context.Background(), []PinnedImage{image}, conf)
+	assert.ErrorIs(t, err, ErrImageFromBundle)
+	assert.NoError(t, dc.ensureImage(context.Background(), image, conf))
+	assert.Equal(t, 1, manager.pulls)
+	assert.False(t, loadedFromBundle(image.RepoDigest))
+}
+
+func TestBundlePathsStayInBundles(t *testing.T) {
+	t.Setenv("VIAM_MODULE_DATA", t.TempDir())
+	dir, err := bundleDirectory()
+	assert.NoError(t, err)
+
+	path, err := resolveBundlePath("seed.tar")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "seed.tar"), path)
+	for _, path := range []string{"../../x.tar", "sub/../../x.tar", "/etc/passwd", filepath.Join(dir, "seed.tar")} {
+		_, err := resolveBundlePath(path)
+		assert.ErrorIs(t, err, ErrBundlePathOutsideBundles, path)
+	}
+
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	_, err = dc.doImportBundle(context.Background(), map[string]interface{}{"path": "../pulled_images.json"})
+	assert.ErrorIs(t, err, ErrBundlePathOutsideBundles)
+	for _, name := range []string{"../../x", "/tmp/x", "sub/x", ".."} {
+		_, _, err := dc.exportBundle(context.Background(), validRunConfig(), name)
+		assert.ErrorIs(t, err, ErrInvalidBundleName, name)
+	}
+}
+
+func TestOfflineImageTag(t *testing.T) {
+	image := PinnedImage{ImageName: "ghcr.io/viam/app:latest", RepoDigest: "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a"}
+	tag, err := offlineImageTag(image)
+	assert.NoError(t, err)
+	assert.Equal(t, "ghcr.io/viam/app:sha256-04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a", tag)
+	assert.Equal(t, "ghcr.io/viam/app@"+image.RepoDigest, repoDigestFromOfflineTags([]string{"ghcr.io/viam/app:latest", tag}))
+	assert.Equal(t, "", repoDigestFromOfflineTags([]string{"ubuntu:22.04"}))
+}
+
+func TestNamedVolumes(t *testing.T) {
+	conf := &Config{RunOptions: &RunOptions{HostOptions: map[string]interface{}{"Binds": "maps:/data,/tmp:/tmp,./rel:/rel"}}}
+	volumes, err := namedVolumes(conf)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"maps"}, volumes)
+}
@@ -0,0 +1,162 @@
+package docker_deploy
+
+import (
+	"sort"
+
+	compose_types "github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+)
+
+// Services that don't list any networks are put on the compose default network. The module leaves them on the
+// daemon's bridge network instead, as it did before compose networks were supported.
+const composeDefaultNetwork = "default"
+
+// composeResourceName returns the name of a network or volume declared in a compose file. loadComposeProject doesn't
+// set a project name, so compose-go names resources without a name of their own _<key>. The module names them after
+// the key, like networks and volumes in run_options.
+func composeResourceName(key string, name string) string {
+	if name == "" || name == "_"+key {
+		return key
+	}
+	return name
+}
+
+// composeNetworks returns the networks the compose file declares that the module manages, external networks are
+// expected to exist already
+func composeNetworks(project *compose_types.Project) []NetworkOptions {
+	keys := make([]string, 0, len(project.Networks))
+	for key := range project.Networks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var networks []NetworkOptions
+	for _, key := range keys {
+		n := project.Networks[key]
+		if key == composeDefaultNetwork || n.External.External {
+			continue
+		}
+		options := NetworkOptions{
+			Name:   composeResourceName(key, n.Name),
+			Driver: n.Driver,
+			Parent: n.DriverOpts["parent"],
+			Labels: n.Labels,
+		}
+		if len(n.Ipam.Config) > 0 && n.Ipam.Config[0] != nil {
+			options.Subnet = n.Ipam.Config[0].Subnet
+			options.Gateway = n.Ipam.Config[0].Gateway
+		}
+		networks = append(networks, options)
+	}
+	return networks
+}
+
+// composeVolumes returns the volumes the compose file declares that the module manages, external volumes are
+// expected to exist already
+func composeVolumes(project *compose_types.Project, onRemove string) []VolumeOptions {
+	keys := make([]string, 0, len(project.Volumes))
+	for key := range project.Volumes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var volumes []VolumeOptions
+	for _, key := range keys {
+		v := project.Volumes[key]
+		if v.External.External {
+			continue
+		}
+		volumes = append(volumes, VolumeOptions{
+			Name:       composeResourceName(key, v.Name),
+			Driver:     v.Driver,
+			DriverOpts: v.DriverOpts,
+			Labels:     v.Labels,
+			OnRemove:   onRemove,
+		})
+	}
+	return volumes
+}
+
+// composeServiceMounts returns the volumes and bind mounts of a service
+func composeServiceMounts(project *compose_types.Project, service compose_types.ServiceConfig) []mount.Mount {
+	var mounts []mount.Mount
+	for _, v := range service.Volumes {
+		switch v.Type {
+		case compose_types.VolumeTypeVolume:
+			source := v.Source
+			if declared, ok := project.Volumes[v.Source]; ok {
+				source = composeResourceName(v.Source, declared.Name)
+			}
+			mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Source: source, Target: v.Target, ReadOnly: v.ReadOnly})
+		case compose_types.VolumeTypeBind:
+			mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: v.Source, Target: v.Target, ReadOnly: v.ReadOnly})
+		}
+	}
+	return mounts
+}
+
+// composeServiceNetworks returns the networks a service is attached to, by name, with the service's endpoint settings
+func composeServiceNetworks(project *compose_types.Project, service compose_types.ServiceConfig) ([]string, map[string]*network.EndpointSettings) {
+	var names []string
+	endpoints := map[string]*network.EndpointSettings{}
+	for key, config := range service.Networks {
+		if key == composeDefaultNetwork {
+			continue
+		}
+		name := key
+		if declared, ok := project.Networks[key]; ok {
+			name = composeResourceName(key, declared.Name)
+		}
+		settings := &network.EndpointSettings{}
+		if config != nil {
+			settings.Aliases = config.Aliases
+			if config.Ipv4Address != "" {
+				settings.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: config.Ipv4Address}
+			}
+		}
+		names = append(names, name)
+		endpoints[name] = settings
+	}
+	// Like compose, the service is created on the network listed first alphabetically and connected to the others
+	sort.Strings(names)
+	return names, endpoints
+}
+
+// composeVolumeOnRemove is what happens to the compose file's volumes once no component uses them
+func (conf *Config) composeVolumeOnRemove() string {
+	if conf.onClose() == OnCloseRemove {
+		return VolumeOnRemoveRemove
+	}
+	return VolumeOnRemoveKeep
+}
+
+// networks returns the networks the module manages for the config, from run_options or the compose file
+func (conf *Config) networks() ([]NetworkOptions, error) {
+	if conf.RunOptions != nil {
+		return conf.RunOptions.Networks, nil
+	}
+	if conf.ComposeOptions == nil {
+		return nil, nil
+	}
+	project, err := loadComposeProject(conf.ImageName, conf.ComposeOptions.ComposeFile)
+	if err != nil {
+		return nil, err
+	}
+	return composeNetworks(project), nil
+}
+
+// volumes returns the volumes the module manages for the config, from run_options or the compose file
+func (conf *Config) volumes() ([]VolumeOptions, error) {
+	if conf.RunOptions != nil {
+		return conf.RunOptions.Volumes, nil
+	}
+	if conf.ComposeOptions == nil {
+		return nil, nil
+	}
+	project, err := loadComposeProject(conf.ImageName, conf.ComposeOptions.ComposeFile)
+	if err != nil {
+		return nil, err
+	}
+	return composeVolumes(project, conf.composeVolumeOnRemove()), nil
+}
@@ -0,0 +1,101 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+)
+
+func composeConfig(onClose string) *Config {
+	return &Config{
+		ImageName:  "ubuntu",
+		RepoDigest: "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		OnClose:    onClose,
+		ComposeOptions: &ComposeOptions{ComposeFile: []string{
+			"services:",
+			"  app:",
+			"    image: ubuntu@sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+			"    networks:",
+			"      frontend:",
+			"      backend:",
+			"        aliases: [api]",
+			"    volumes:",
+			"      - maps:/maps",
+			"      - /opt/config:/config:ro",
+			"  worker:",
+			"    image: ubuntu@sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+			"networks:",
+			"  backend:",
+			"    ipam:",
+			"      config:",
+			"        - subnet: 172.28.0.0/16",
+			"  frontend:",
+			"    name: robot-frontend",
+			"  lan:",
+			"    external: true",
+			"volumes:",
+			"  maps:",
+			"  shared:",
+			"    external: true",
+		}},
+	}
+}
+
+func TestComposeNetworksAndVolumes(t *testing.T) {
+	conf := composeConfig("")
+	networks, err := conf.networks()
+	assert.NoError(t, err)
+	assert.Equal(t, []NetworkOptions{
+		{Name: "backend", Subnet: "172.28.0.0/16"},
+		{Name: "robot-frontend"},
+	}, networks)
+
+	volumes, err := conf.volumes()
+	assert.NoError(t, err)
+	assert.Equal(t, []VolumeOptions{{Name: "maps", OnRemove: VolumeOnRemoveKeep}}, volumes)
+
+	// Compose volumes only go with the component when its containers do
+	volumes, err = composeConfig(OnCloseRemove).volumes()
+	assert.NoError(t, err)
+	assert.Equal(t, VolumeOnRemoveRemove, volumes[0].OnRemove)
+}
+
+func TestComposeServiceMountsAndNetworks(t *testing.T) {
+	conf := composeConfig("")
+	project, err := loadComposeProject(conf.ImageName, conf.ComposeOptions.ComposeFile)
+	assert.NoError(t, err)
+	app, err := project.GetService("app")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeVolume, Source: "maps", Target: "/maps"},
+		{Type: mount.TypeBind, Source: "/opt/config", Target: "/config", ReadOnly: true},
+	}, composeServiceMounts(project, app))
+
+	names, endpoints := composeServiceNetworks(project, app)
+	assert.Equal(t, []string{"backend", "robot-frontend"}, names)
+	assert.Equal(t, []string{"api"}, endpoints["backend"].Aliases)
+
+	// Services without networks stay on the daemon's bridge network
+	worker, err := project.GetService("worker")
+	assert.NoError(t, err)
+	names, _ = composeServiceNetworks(project, worker)
+	assert.Empty(t, names)
+}
+
+func TestEnsureComposeNetworksAndVolumes(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	conf := composeConfig(OnCloseRemove)
+	assert.NoError(t, dc.ensureNetworks(context.Background(), conf))
+	assert.NoError(t, dc.ensureVolumes(context.Background(), conf))
+	assert.Contains(t, manager.networks, "backend")
+	assert.Contains(t, manager.networks, "robot-frontend")
+	assert.Equal(t, VolumeOnRemoveRemove, manager.volumes["maps"][volumeOnRemoveLabel])
+
+	// The registries release them with the component like run_options networks and volumes
+	assert.Equal(t, []string{"backend", "robot-frontend"}, networkNames(conf))
+	assert.Equal(t, []string{"maps"}, volumeNames(conf))
+}
@@ -2,9 +2,13 @@ package docker_deploy
 
 import (
 	"errors"
+	"fmt"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
+	"go.viam.com/rdk/resource"
 	"go.viam.com/rdk/utils"
 )
 
@@ -15,19 +19,51 @@ var ErrComposeFileRequired = errors.New("compose_file is required")
 var ErrComposeRepoDigestRequired = errors.New("repo_digest is required in compose_file")
 var ErrUsernameIsRequired = errors.New("credentials.username is required")
 var ErrPasswordIsRequired = errors.New("credentials.password is required")
+var ErrRegistryHostRequired = errors.New("credentials must be keyed by registry host")
+var ErrPasswordAndPasswordFileSet = errors.New("only one of credentials.password or credentials.password_file can be set")
+var ErrEnvFromFilesName = errors.New("env_from_files keys must be environment variable names")
+var ErrSecretFileRequired = errors.New("a secret file path is required")
+var ErrPullRetryNegative = errors.New("pull_retry values must not be negative")
+var ErrTagAndRepoDigestSet = errors.New("only one of tag or repo_digest can be set")
+var ErrTagWithCompose = errors.New("tag is not supported with compose_options")
+var ErrUpdatePolicyRequiresTag = errors.New("update_policy requires tag")
+var ErrUnknownUpdateMode = errors.New("update_policy.mode must be manual or poll")
+var ErrPollIntervalNegative = errors.New("update_policy.poll_interval_minutes must not be negative")
+var ErrPlatformDigestsWithTag = errors.New("platform_digests can't be used with tag")
+var ErrPlatformDigestsWithCompose = errors.New("platform_digests is not supported with compose_options")
+var ErrPlatformDigestInvalid = errors.New("platform_digests values must be sha256 digests")
+var ErrMinFreeBytesNegative = errors.New("min_free_bytes must not be negative")
 var ErrAutoRemoveType = errors.New("host_options 'AutoRemove' parameter must be a boolean")
 var ErrBindType = errors.New("host_options 'Binds' parameter must include a non-empty string")
 var ErrNetworkModeType = errors.New("host_options 'Network Mode' parameter must include a non-empty string")
 
 type Config struct {
-	Attributes     utils.AttributeMap `json:"attributes,omitempty"`
-	RunOptions     *RunOptions        `json:"run_options"`
-	ComposeOptions *ComposeOptions    `json:"compose_options"`
-	ImageName      string             `json:"image_name"`
-	RepoDigest     string             `json:"repo_digest"`
-	RunOnce        bool               `json:"run_once"`
-	DownloadOnly   bool               `json:"download_only"`
-	Credentials    *Credentials       `json:"credentials"`
+	Attributes        utils.AttributeMap      `json:"attributes,omitempty"`
+	RunOptions        *RunOptions             `json:"run_options"`
+	ComposeOptions    *ComposeOptions         `json:"compose_options"`
+	ImageName         string                  `json:"image_name"`
+	RepoDigest        string                  `json:"repo_digest"`
+	Tag               string                  `json:"tag"`
+	PlatformDigests   map[string]string       `json:"platform_digests"`
+	UpdatePolicy      *UpdatePolicy           `json:"update_policy"`
+	MaintenanceWindow *MaintenanceWindow      `json:"maintenance_window"`
+	RunOnce           bool                    `json:"run_once"`
+	DownloadOnly      bool                    `json:"download_only"`
+	Credentials       map[string]*Credentials `json:"credentials"`
+	DockerConfigPath  string                  `json:"docker_config_path"`
+	SecretsDirectory  string                  `json:"secrets_dir"`
+	PullRetry         *PullRetryOptions       `json:"pull_retry"`
+	PullPriority      int                     `json:"pull_priority"`
+	MinFreeBytes      int64                   `json:"min_free_bytes"`
+	PruneUnusedImages bool                    `json:"prune_unused_images"`
+	Verify            *VerifyOptions          `json:"verify"`
+	Probes            []ProbeOptions          `json:"probes"`
+	StopSignal        string                  `json:"stop_signal"`
+	StopTimeout       int                     `json:"stop_timeout_seconds"`
+	PreStop           []string                `json:"pre_stop"`
+	OnClose           string                  `json:"on_close"`
+	Runtime           string                  `json:"runtime"`
+	Templates         *TemplateOptions        `json:"templates"`
 }
 
 // This is for docker compose based configs
@@ -37,34 +73,150 @@ type ComposeOptions struct {
 
 type RunOptions struct {
 	Env            []string               `json:"env"`
+	EnvFromFiles   map[string]string      `json:"env_from_files"`
 	EntryPointArgs []string               `json:"entry_point_args"`
 	Options        map[string]interface{} `json:"options"`
 	HostOptions    map[string]interface{} `json:"host_options"`
+	Ports          []string               `json:"ports"`
+	Networks       []NetworkOptions       `json:"networks"`
+	Volumes        []VolumeOptions        `json:"volumes"`
+	Healthcheck    *HealthcheckOptions    `json:"healthcheck"`
 }
 
+// How failed pulls are retried, unset fields use the defaults below
+type PullRetryOptions struct {
+	MaxAttempts           int     `json:"max_attempts"`
+	InitialBackoffSeconds float64 `json:"initial_backoff_seconds"`
+	MaxBackoffSeconds     float64 `json:"max_backoff_seconds"`
+}
+
+const defaultPullMaxAttempts = 5
+const defaultPullInitialBackoff = 5 * time.Second
+const defaultPullMaxBackoff = 5 * time.Minute
+
+// get returns the retry options with the defaults applied
+func (ro *PullRetryOptions) get() (int, time.Duration, time.Duration) {
+	maxAttempts, initialBackoff, maxBackoff := defaultPullMaxAttempts, defaultPullInitialBackoff, defaultPullMaxBackoff
+	if ro == nil {
+		return maxAttempts, initialBackoff, maxBackoff
+	}
+	if ro.MaxAttempts > 0 {
+		maxAttempts = ro.MaxAttempts
+	}
+	if ro.InitialBackoffSeconds > 0 {
+		initialBackoff = time.Duration(ro.InitialBackoffSeconds * float64(time.Second))
+	}
+	if ro.MaxBackoffSeconds > 0 {
+		maxBackoff = time.Duration(ro.MaxBackoffSeconds * float64(time.Second))
+	}
+	return maxAttempts, initialBackoff, max(initialBackoff, maxBackoff)
+}
+
+const (
+	UpdateModeManual = "manual"
+	UpdateModePoll   = "poll"
+)
+
+const defaultUpdatePollInterval = time.Hour
+
+// How an image configured by tag is kept up to date, without a policy updates are manual
+type UpdatePolicy struct {
+	Mode                string  `json:"mode"`
+	PollIntervalMinutes float64 `json:"poll_interval_minutes"`
+}
+
+func (up *UpdatePolicy) mode() string {
+	if up == nil || up.Mode == "" {
+		return UpdateModeManual
+	}
+	return up.Mode
+}
+
+func (up *UpdatePolicy) pollInterval() time.Duration {
+	if up == nil || up.PollIntervalMinutes <= 0 {
+		return defaultUpdatePollInterval
+	}
+	return time.Duration(up.PollIntervalMinutes * float64(time.Minute))
+}
+
+// Credentials for a single registry, Config.Credentials is keyed by the registry host (e.g. ghcr.io or docker.io)
 type Credentials struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password_file"`
+}
+
+// convertAttributes converts the attributes of a component to its config. Configs written before credentials were
+// keyed by registry host have a single username and password, which are used for the registry of image_name.
+func convertAttributes(attributes utils.AttributeMap) (*Config, error) {
+	if credentials, ok := attributes["credentials"].(map[string]interface{}); ok && isLegacyCredentials(credentials) {
+		host := ""
+		if imageName, ok := attributes["image_name"].(string); ok {
+			host, _ = registryHost(imageName)
+		}
+		converted := make(utils.AttributeMap, len(attributes))
+		for k, v := range attributes {
+			converted[k] = v
+		}
+		converted["credentials"] = map[string]interface{}{host: credentials}
+		attributes = converted
+	}
+	return resource.TransformAttributeMap[*Config](attributes)
+}
+
+func isLegacyCredentials(credentials map[string]interface{}) bool {
+	for _, key := range []string{"username", "password", "password_file"} {
+		if _, ok := credentials[key].(string); ok {
+			return true
+		}
+	}
+	return false
 }
 
 func (conf *Config) HasChanged(newConf *Config) bool {
+	return conf.ContainersChanged(newConf) || conf.CredentialsChanged(newConf)
+}
+
+// ContainersChanged reports whether the containers need to be recreated for the new config
+func (conf *Config) ContainersChanged(newConf *Config) bool {
 	if conf.ImageName != newConf.ImageName ||
 		conf.RepoDigest != newConf.RepoDigest {
 		return true
 	}
+	// Switching between run_options and compose_options, or whether the containers run at all, replaces them
+	if (conf.RunOptions == nil) != (newConf.RunOptions == nil) ||
+		conf.DownloadOnly != newConf.DownloadOnly ||
+		conf.RunOnce != newConf.RunOnce {
+		return true
+	}
+	// The images are verified before their containers are created, so changing how replaces them
+	if !reflect.DeepEqual(conf.Verify, newConf.Verify) {
+		return true
+	}
 	if conf.RunOptions != nil && newConf.RunOptions != nil {
 		return !stringSliceEqual(conf.RunOptions.Env, newConf.RunOptions.Env) ||
 			!stringSliceEqual(conf.RunOptions.EntryPointArgs, newConf.RunOptions.EntryPointArgs) ||
 			!mapsEqual(conf.RunOptions.Options, newConf.RunOptions.Options) ||
 			!mapsEqual(conf.RunOptions.HostOptions, newConf.RunOptions.HostOptions) ||
-			(conf.Credentials != nil && newConf.Credentials != nil && (conf.Credentials.Username != newConf.Credentials.Username || conf.Credentials.Password != newConf.Credentials.Password))
+			!stringSliceEqual(conf.RunOptions.Ports, newConf.RunOptions.Ports) ||
+			!reflect.DeepEqual(conf.RunOptions.Networks, newConf.RunOptions.Networks) ||
+			!reflect.DeepEqual(conf.RunOptions.Volumes, newConf.RunOptions.Volumes) ||
+			!reflect.DeepEqual(conf.RunOptions.Healthcheck, newConf.RunOptions.Healthcheck) ||
+			!reflect.DeepEqual(conf.RunOptions.EnvFromFiles, newConf.RunOptions.EnvFromFiles) ||
+			conf.SecretsDirectory != newConf.SecretsDirectory
 	} else if conf.ComposeOptions != nil && newConf.ComposeOptions != nil {
-		return !stringSliceEqual(conf.ComposeOptions.ComposeFile, newConf.ComposeOptions.ComposeFile) ||
-			(conf.Credentials != nil && newConf.Credentials != nil && (conf.Credentials.Username != newConf.Credentials.Username || conf.Credentials.Password != newConf.Credentials.Password))
+		return !stringSliceEqual(conf.ComposeOptions.ComposeFile, newConf.ComposeOptions.ComposeFile)
 	}
 	return false
 }
 
+// CredentialsChanged reports whether the credentials used for pulls have changed, including being added or removed
+func (conf *Config) CredentialsChanged(newConf *Config) bool {
+	return !credentialsEqual(conf.Credentials, newConf.Credentials) ||
+		conf.DockerConfigPath != newConf.DockerConfigPath ||
+		conf.SecretsDirectory != newConf.SecretsDirectory
+}
+
 func (conf *Config) Validate(path string) ([]string, error) {
 	var validationErrors []error
 	if conf.RunOptions != nil && conf.ComposeOptions != nil {
@@ -75,10 +227,51 @@ func (conf *Config) Validate(path string) ([]string, error) {
 		validationErrors = append(validationErrors, ErrImageNameRequired)
 	}
 
-	if conf.RepoDigest == "" {
+	if conf.Tag != "" {
+		if conf.RepoDigest != "" {
+			validationErrors = append(validationErrors, ErrTagAndRepoDigestSet)
+		}
+		if conf.ComposeOptions != nil {
+			return nil, ErrTagWithCompose
+		}
+	} else if conf.RepoDigest == "" && len(conf.PlatformDigests) == 0 {
 		validationErrors = append(validationErrors, ErrRepoDigestRequired)
 	}
 
+	if len(conf.PlatformDigests) > 0 {
+		if conf.Tag != "" {
+			validationErrors = append(validationErrors, ErrPlatformDigestsWithTag)
+		}
+		if conf.ComposeOptions != nil {
+			return nil, ErrPlatformDigestsWithCompose
+		}
+		for key, digest := range conf.PlatformDigests {
+			if _, err := parsePlatform(key); err != nil {
+				validationErrors = append(validationErrors, err)
+			}
+			if !strings.HasPrefix(digest, "sha256:") {
+				validationErrors = append(validationErrors, fmt.Errorf("%s: %w", key, ErrPlatformDigestInvalid))
+			}
+		}
+	}
+
+	if conf.UpdatePolicy != nil {
+		if conf.Tag == "" {
+			validationErrors = append(validationErrors, ErrUpdatePolicyRequiresTag)
+		}
+		if mode := conf.UpdatePolicy.mode(); mode != UpdateModeManual && mode != UpdateModePoll {
+			validationErrors = append(validationErrors, fmt.Errorf("%w: %q", ErrUnknownUpdateMode, mode))
+		}
+		if conf.UpdatePolicy.PollIntervalMinutes < 0 {
+			validationErrors = append(validationErrors, ErrPollIntervalNegative)
+		}
+	}
+	validationErrors = append(validationErrors, conf.MaintenanceWindow.validate()...)
+	var deps []string
+	if conf.MaintenanceWindow != nil && conf.MaintenanceWindow.Dependency != nil && conf.MaintenanceWindow.Dependency.Resource != "" {
+		deps = append(deps, conf.MaintenanceWindow.Dependency.Resource)
+	}
+
 	if conf.ComposeOptions != nil {
 		if conf.ComposeOptions.ComposeFile == nil {
 			validationErrors = append(validationErrors, ErrComposeFileRequired)
@@ -109,18 +302,78 @@ func (conf *Config) Validate(path string) ([]string, error) {
 				validationErrors = append(validationErrors, ErrAutoRemoveType)
 			}
 		}
+		validationErrors = append(validationErrors, conf.RunOptions.Healthcheck.validate()...)
+	}
+
+	if conf.PullRetry != nil {
+		if conf.PullRetry.MaxAttempts < 0 || conf.PullRetry.InitialBackoffSeconds < 0 || conf.PullRetry.MaxBackoffSeconds < 0 {
+			validationErrors = append(validationErrors, ErrPullRetryNegative)
+		}
+	}
+
+	if conf.MinFreeBytes < 0 {
+		validationErrors = append(validationErrors, ErrMinFreeBytesNegative)
 	}
+	validationErrors = append(validationErrors, validatePorts(conf)...)
+	validationErrors = append(validationErrors, validateNetworks(conf)...)
+	validationErrors = append(validationErrors, validateVolumes(conf)...)
+	validationErrors = append(validationErrors, conf.Verify.validate()...)
+	validationErrors = append(validationErrors, validateProbes(conf)...)
+	validationErrors = append(validationErrors, conf.validateStop()...)
+	validationErrors = append(validationErrors, conf.validateRuntime()...)
 
-	if conf.Credentials != nil {
-		if conf.Credentials.Username == "" {
-			validationErrors = append(validationErrors, ErrUsernameIsRequired)
+	// The component's name isn't known here, every other variable is checked with the value it has on this machine
+	expanded, templateErrors := conf.withTemplates(conf.Templates.lookup(""))
+	validationErrors = append(validationErrors, templateErrors...)
+	validationErrors = append(validationErrors, expanded.policyViolations()...)
+
+	for host, creds := range conf.Credentials {
+		if host == "" {
+			validationErrors = append(validationErrors, ErrRegistryHostRequired)
+			continue
+		}
+		if creds == nil || creds.Username == "" {
+			validationErrors = append(validationErrors, fmt.Errorf("%s: %w", host, ErrUsernameIsRequired))
+		}
+		if creds == nil || (creds.Password == "" && creds.PasswordFile == "") {
+			validationErrors = append(validationErrors, fmt.Errorf("%s: %w", host, ErrPasswordIsRequired))
+		} else if creds.Password != "" && creds.PasswordFile != "" {
+			validationErrors = append(validationErrors, fmt.Errorf("%s: %w", host, ErrPasswordAndPasswordFileSet))
+		} else if creds.PasswordFile != "" {
+			validationErrors = append(validationErrors, validateSecretPath(conf.SecretsDirectory, creds.PasswordFile)...)
 		}
-		if conf.Credentials.Password == "" {
-			validationErrors = append(validationErrors, ErrPasswordIsRequired)
+	}
+
+	if conf.RunOptions != nil {
+		for name, path := range conf.RunOptions.EnvFromFiles {
+			if name == "" || strings.ContainsAny(name, "= ") {
+				validationErrors = append(validationErrors, fmt.Errorf("%w: %q", ErrEnvFromFilesName, name))
+			}
+			if path == "" {
+				validationErrors = append(validationErrors, fmt.Errorf("env_from_files %s: %w", name, ErrSecretFileRequired))
+				continue
+			}
+			validationErrors = append(validationErrors, validateSecretPath(conf.SecretsDirectory, path)...)
 		}
 	}
 
-	return nil, errors.Join(validationErrors...)
+	return deps, errors.Join(validationErrors...)
+}
+
+// validateSecretPath makes sure a secret file can't point outside of the secrets directory
+func validateSecretPath(secretsDir string, path string) []error {
+	if !filepath.IsAbs(path) {
+		if !filepath.IsLocal(path) {
+			return []error{fmt.Errorf("%s: %w", path, ErrSecretOutsideDirectory)}
+		}
+		return nil
+	}
+	if secretsDir != "" {
+		if rel, err := filepath.Rel(secretsDir, path); err != nil || !filepath.IsLocal(rel) {
+			return []error{fmt.Errorf("%s: %w", path, ErrSecretOutsideDirectory)}
+		}
+	}
+	return nil
 }
 
 // StringSliceEqual checks if two string slices are equal
@@ -136,6 +389,14 @@ func stringSliceEqual(a, b []string) bool {
 	return true
 }
 
+// credentialsEqual checks if two sets of registry credentials are equal, treating nil and empty as equal
+func credentialsEqual(a, b map[string]*Credentials) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
 // mapsEqual checks if two maps are equal
 func mapsEqual(a, b map[string]interface{}) bool {
 	return reflect.DeepEqual(a, b)
@@ -0,0 +1,96 @@
+package docker_deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.viam.com/rdk/utils"
+)
+
+func validRunConfig() *Config {
+	return &Config{
+		ImageName:  "ubuntu",
+		RepoDigest: "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		RunOptions: &RunOptions{EntryPointArgs: []string{"echo", "hi"}},
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	conf := validRunConfig()
+	conf.Credentials = map[string]*Credentials{
+		"ghcr.io":      {Username: "user", Password: "pass"},
+		"harbor.local": {Username: "user"},
+	}
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrPasswordIsRequired)
+	assert.NotErrorIs(t, err, ErrUsernameIsRequired)
+
+	conf.Credentials["harbor.local"].Password = "pass"
+	_, err = conf.Validate("")
+	assert.NoError(t, err)
+}
+
+func TestContainersChanged(t *testing.T) {
+	conf := validRunConfig()
+	assert.False(t, conf.ContainersChanged(validRunConfig()))
+
+	downloadOnly := validRunConfig()
+	downloadOnly.DownloadOnly = true
+	assert.True(t, conf.ContainersChanged(downloadOnly))
+
+	verify := validRunConfig()
+	verify.Verify = &VerifyOptions{PublicKeys: []string{"key"}}
+	assert.True(t, conf.ContainersChanged(verify))
+
+	compose := &Config{ImageName: conf.ImageName, RepoDigest: conf.RepoDigest, ComposeOptions: &ComposeOptions{}}
+	assert.True(t, conf.ContainersChanged(compose))
+	assert.True(t, compose.ContainersChanged(conf))
+}
+
+func TestConvertLegacyCredentials(t *testing.T) {
+	conf, err := convertAttributes(utils.AttributeMap{
+		"image_name":  "ghcr.io/viam-soleng/app",
+		"repo_digest": "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		"credentials": map[string]interface{}{"username": "user", "password": "pass"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*Credentials{"ghcr.io": {Username: "user", Password: "pass"}}, conf.Credentials)
+
+	// Docker Hub images use docker.io
+	conf, err = convertAttributes(utils.AttributeMap{
+		"image_name":  "ubuntu",
+		"credentials": map[string]interface{}{"username": "user", "password_file": "/run/secrets/hub"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*Credentials{"docker.io": {Username: "user", PasswordFile: "/run/secrets/hub"}}, conf.Credentials)
+
+	conf, err = convertAttributes(utils.AttributeMap{
+		"image_name":  "ubuntu",
+		"credentials": map[string]interface{}{"ghcr.io": map[string]interface{}{"username": "user", "password": "pass"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*Credentials{"ghcr.io": {Username: "user", Password: "pass"}}, conf.Credentials)
+}
+
+func TestCredentialsChanged(t *testing.T) {
+	oldConf := validRunConfig()
+	newConf := validRunConfig()
+	assert.False(t, oldConf.HasChanged(newConf))
+
+	// Adding credentials to a config that had none is a change
+	newConf.Credentials = map[string]*Credentials{"ghcr.io": {Username: "user", Password: "pass"}}
+	assert.True(t, oldConf.HasChanged(newConf))
+	assert.True(t, oldConf.CredentialsChanged(newConf))
+	assert.False(t, oldConf.ContainersChanged(newConf))
+
+	// So is rotating a password
+	oldConf.Credentials = map[string]*Credentials{"ghcr.io": {Username: "user", Password: "old"}}
+	assert.True(t, oldConf.CredentialsChanged(newConf))
+
+	oldConf.Credentials["ghcr.io"].Password = "pass"
+	assert.False(t, oldConf.HasChanged(newConf))
+
+	newConf.Credentials = map[string]*Credentials{}
+	oldConf.Credentials = nil
+	assert.False(t, oldConf.CredentialsChanged(newConf))
+}
@@ -0,0 +1,349 @@
+package docker_deploy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrInsufficientDiskSpace = errors.New("insufficient disk space")
+
+// Layers are stored extracted, which usually takes about twice the compressed size reported by the registry
+const imageExtractionFactor = 2
+
+// The /system/df API walks every layer and volume, so the figures reported in Readings are cached
+const diskUsageCacheTTL = time.Minute
+
+// DiskUsage is the disk usage reported by the daemon's /system/df API and the free space on its data root
+type DiskUsage struct {
+	DataRoot        string
+	FreeBytes       int64 // -1 if the data root isn't visible to the module, e.g. the daemon runs in a VM
+	LayersBytes     int64
+	ContainersBytes int64
+	VolumesBytes    int64
+	BuildCacheBytes int64
+	Images          []DiskUsageImage
+}
+
+type DiskUsageImage struct {
+	ID          string
+	RepoDigests []string
+	RepoTags    []string
+	Created     time.Time
+	// Bytes used only by this image, which is what removing it frees
+	UniqueSize int64
+	Containers int64
+}
+
+// Status returns the disk usage in a form that can be returned from Readings
+func (du *DiskUsage) Status() map[string]interface{} {
+	var reclaimable int64
+	for _, image := range du.Images {
+		if image.Containers == 0 {
+			reclaimable += image.UniqueSize
+		}
+	}
+	return map[string]interface{}{
+		"data_root":         du.DataRoot,
+		"free_bytes":        du.FreeBytes,
+		"layers_bytes":      du.LayersBytes,
+		"containers_bytes":  du.ContainersBytes,
+		"volumes_bytes":     du.VolumesBytes,
+		"build_cache_bytes": du.BuildCacheBytes,
+		"images":            len(du.Images),
+		"reclaimable_bytes": reclaimable,
+	}
+}
+
+// repoDigests returns the digests the image is known by, including the offline tag of images the module loaded from a
+// bundle
+func (image DiskUsageImage) repoDigests(loaded map[string]string) []string {
+	var digests []string
+	for _, repoDigest := range image.RepoDigests {
+		if _, digest, ok := strings.Cut(repoDigest, "@"); ok {
+			digests = append(digests, digest)
+		}
+	}
+	if _, digest, ok := strings.Cut(trustedOfflineRepoDigest(image.RepoTags, image.ID, loaded), "@"); ok {
+		digests = append(digests, digest)
+	}
+	return digests
+}
+
+// freeBytes returns the space available to unprivileged writers on the filesystem holding path, or -1 if unknown
+func freeBytes(path string) int64 {
+	var stat syscall.Statfs_t
+	if path == "" || syscall.Statfs(path, &stat) != nil {
+		return -1
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize)
+}
+
+// The images configured by every component of the module, which garbage collection must never remove
+var moduleImages = &imageRegistry{byComponent: map[string][]string{}}
+
+type imageRegistry struct {
+	mu          sync.Mutex
+	byComponent map[string][]string
+}
+
+func (ir *imageRegistry) set(component string, images []PinnedImage) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	digests := make([]string, 0, len(images))
+	for _, image := range images {
+		digests = append(digests, image.RepoDigest)
+	}
+	ir.byComponent[component] = digests
+}
+
+func (ir *imageRegistry) remove(component string) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	delete(ir.byComponent, component)
+}
+
+func (ir *imageRegistry) contains(digest string) bool {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	for _, digests := range ir.byComponent {
+		for _, d := range digests {
+			if d == digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The digests of the images the module pulled or loaded from a bundle, recorded in VIAM_MODULE_DATA so only those
+// images are ever pruned
+const pulledImagesFileName = "pulled_images.json"
+
+var pulledImagesMu sync.Mutex
+
+func pulledImagesPath() (string, error) {
+	dir, err := moduleDataDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, pulledImagesFileName), nil
+}
+
+// readPulledImages returns the recorded digests, pulledImagesMu must be held
+func readPulledImages(path string) (map[string]bool, error) {
+	pulled := map[string]bool{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return pulled, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	if err := json.Unmarshal(data, &digests); err != nil {
+		return nil, err
+	}
+	for _, digest := range digests {
+		pulled[digest] = true
+	}
+	return pulled, nil
+}
+
+// writePulledImages replaces the recorded digests, pulledImagesMu must be held
+func writePulledImages(path string, pulled map[string]bool) error {
+	digests := make([]string, 0, len(pulled))
+	for digest := range pulled {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	data, err := json.Marshal(digests)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// updatePulledImages adds and removes digests from the record
+func updatePulledImages(add []string, remove []string) error {
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	path, err := pulledImagesPath()
+	if err != nil {
+		return err
+	}
+	pulled, err := readPulledImages(path)
+	if err != nil {
+		return err
+	}
+	for _, digest := range add {
+		pulled[digest] = true
+	}
+	for _, digest := range remove {
+		delete(pulled, digest)
+	}
+	return writePulledImages(path, pulled)
+}
+
+// pulledImages returns the digests of the images the module pulled
+func pulledImages() (map[string]bool, error) {
+	pulledImagesMu.Lock()
+	defer pulledImagesMu.Unlock()
+	path, err := pulledImagesPath()
+	if err != nil {
+		return nil, err
+	}
+	return readPulledImages(path)
+}
+
+// recordPulledImages remembers that the module brought the images onto the host, so they may be pruned later
+func (dc *DockerConfig) recordPulledImages(digests ...string) {
+	if err := updatePulledImages(digests, nil); err != nil {
+		dc.logger.Warnf("Unable to record pulled images %v, they won't be pruned: %v", digests, err)
+	}
+}
+
+// checkDiskSpace makes sure the image and min_free_bytes fit on the Docker data root before pulling. The size of the
+// image is estimated from its manifest, if the registry can't be reached only min_free_bytes is checked.
+func (dc *DockerConfig) checkDiskSpace(ctx context.Context, image PinnedImage, conf *Config) error {
+	if conf.MinFreeBytes <= 0 {
+		return nil
+	}
+	return dc.ensureFreeSpace(ctx, conf.MinFreeBytes+dc.estimateImageSize(ctx, image, conf), conf)
+}
+
+// estimateImageSize returns the disk space the image is expected to need once extracted, or 0 if it can't be estimated
+func (dc *DockerConfig) estimateImageSize(ctx context.Context, image PinnedImage, conf *Config) int64 {
+	platform, err := dc.manager.HostPlatform(ctx)
+	if err != nil {
+		dc.logger.Warnf("Unable to estimate the size of %s: %v", image, err)
+		return 0
+	}
+	manifest, err := registryClientFor(conf).GetPlatformManifest(ctx, image.ImageName, image.RepoDigest, platform)
+	if err != nil {
+		dc.logger.Warnf("Unable to estimate the size of %s, only checking min_free_bytes: %v", image, err)
+		return 0
+	}
+	return manifest.Size() * imageExtractionFactor
+}
+
+// ensureFreeSpace makes sure required bytes are free on the Docker data root, removing unused images first if
+// prune_unused_images is set
+func (dc *DockerConfig) ensureFreeSpace(ctx context.Context, required int64, conf *Config) error {
+	if required <= 0 {
+		return nil
+	}
+	usage, err := dc.diskUsage(ctx)
+	if err != nil {
+		return err
+	}
+	if usage.FreeBytes < 0 {
+		dc.logger.Warnf("Unable to determine the free space on %s, skipping the disk space check", usage.DataRoot)
+		return nil
+	}
+	if usage.FreeBytes >= required {
+		return nil
+	}
+
+	if conf.PruneUnusedImages {
+		dc.pruneUnusedImages(usage, required-usage.FreeBytes)
+		if usage, err = dc.diskUsage(ctx); err != nil {
+			return err
+		}
+		if usage.FreeBytes >= required {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d bytes free on %s, %d bytes needed", ErrInsufficientDiskSpace, usage.FreeBytes, usage.DataRoot, required)
+}
+
+// pruneUnusedImages removes the oldest images that the module pulled, no container uses and no component of the
+// module is configured with, until needed bytes have been freed. Images pulled by anything else are never removed.
+func (dc *DockerConfig) pruneUnusedImages(usage *DiskUsage, needed int64) {
+	pulled, err := pulledImages()
+	if err != nil {
+		dc.logger.Warnf("Unable to read the images pulled by the module, not pruning: %v", err)
+		return
+	}
+	loaded, err := loadedImages()
+	if err != nil {
+		dc.logger.Warnf("Unable to read the images loaded from bundles, not pruning: %v", err)
+		return
+	}
+	candidates := []DiskUsageImage{}
+	for _, image := range usage.Images {
+		if image.Containers != 0 || image.UniqueSize <= 0 {
+			continue
+		}
+		ours, pinned := false, false
+		for _, digest := range image.repoDigests(loaded) {
+			ours = ours || pulled[digest]
+			pinned = pinned || moduleImages.contains(digest)
+		}
+		if ours && !pinned {
+			candidates = append(candidates, image)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Created.Before(candidates[j].Created) })
+
+	var freed int64
+	for _, image := range candidates {
+		if freed >= needed {
+			return
+		}
+		dc.logger.Infof("Removing unused image %s to free %d bytes", image.ID, image.UniqueSize)
+		if err := dc.manager.RemoveImageByImageId(image.ID); err != nil {
+			dc.logger.Warnf("Unable to remove unused image %s: %v", image.ID, err)
+			continue
+		}
+		freed += image.UniqueSize
+		forgotten := map[string]string{}
+		for digest, imageId := range loaded {
+			if imageId == image.ID {
+				forgotten[digest] = ""
+			}
+		}
+		if err := updatePulledImages(nil, image.repoDigests(loaded)); err != nil {
+			dc.logger.Warnf("Unable to forget removed image %s: %v", image.ID, err)
+		}
+		if err := updateLoadedImages(forgotten); err != nil {
+			dc.logger.Warnf("Unable to forget removed image %s: %v", image.ID, err)
+		}
+	}
+}
+
+// diskUsage queries the daemon and refreshes the figures cached for Readings
+func (dc *DockerConfig) diskUsage(ctx context.Context) (*DiskUsage, error) {
+	usage, err := dc.manager.DiskUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dc.diskMu.Lock()
+	defer dc.diskMu.Unlock()
+	dc.lastDiskUsage = usage
+	dc.lastDiskUsageAt = time.Now()
+	return usage, nil
+}
+
+// diskUsageStatus returns the cached disk usage for Readings, refreshing it if it is older than diskUsageCacheTTL
+func (dc *DockerConfig) diskUsageStatus(ctx context.Context) (map[string]interface{}, error) {
+	dc.diskMu.Lock()
+	usage := dc.lastDiskUsage
+	fresh := time.Since(dc.lastDiskUsageAt) < diskUsageCacheTTL
+	dc.diskMu.Unlock()
+	if usage == nil || !fresh {
+		var err error
+		if usage, err = dc.diskUsage(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return usage.Status(), nil
+}
@@ -0,0 +1,70 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskSpacePreflight(t *testing.T) {
+	registry := newFakeRegistry(t, "", "")
+	digest := registry.addMultiPlatformImage("app", "")
+	image := PinnedImage{ImageName: registry.image("app"), RepoDigest: digest}
+
+	newManager := func() *fakeDockerManager {
+		manager := newFakeDockerManager()
+		manager.diskUsage = &DiskUsage{
+			DataRoot:  "/var/lib/docker",
+			FreeBytes: 100,
+			Images: []DiskUsageImage{
+				{ID: "in-use", Created: time.Unix(1, 0), UniqueSize: 500, Containers: 1},
+				{ID: "pinned", Created: time.Unix(2, 0), UniqueSize: 500, RepoDigests: []string{"other@sha256:pinned"}},
+				// Pulled by another tool, so never pruned even though it is the oldest
+				{ID: "foreign", Created: time.Unix(0, 0), UniqueSize: 500, RepoDigests: []string{"other@sha256:foreign"}},
+				{ID: "oldest", Created: time.Unix(3, 0), UniqueSize: 150, RepoDigests: []string{"app@sha256:oldest"}},
+				{ID: "older", Created: time.Unix(4, 0), UniqueSize: 150, RepoDigests: []string{"app@sha256:older"}},
+				{ID: "newest", Created: time.Unix(5, 0), UniqueSize: 150, RepoDigests: []string{"app@sha256:newest"}},
+			},
+		}
+		return manager
+	}
+	t.Setenv("VIAM_MODULE_DATA", t.TempDir())
+	assert.NoError(t, updatePulledImages([]string{"sha256:pinned", "sha256:oldest", "sha256:older", "sha256:newest"}, nil))
+	moduleImages.set("other-component", []PinnedImage{{ImageName: "other", RepoDigest: "sha256:pinned"}})
+	t.Cleanup(func() { moduleImages.remove("other-component") })
+
+	// The arm64 image is 100 bytes compressed, so the pull needs 200 bytes plus min_free_bytes
+	conf := &Config{MinFreeBytes: 50, PullRetry: &PullRetryOptions{MaxAttempts: 1}}
+	manager := newManager()
+	dc := newTestDockerConfig(t, manager)
+	err := dc.ensureImage(context.Background(), image, conf)
+	assert.ErrorIs(t, err, ErrInsufficientDiskSpace)
+	assert.Equal(t, 0, manager.pulls)
+	assert.Empty(t, manager.removedImages)
+
+	conf.PruneUnusedImages = true
+	manager = newManager()
+	dc = newTestDockerConfig(t, manager)
+	assert.NoError(t, dc.ensureImage(context.Background(), image, conf))
+	assert.Equal(t, 1, manager.pulls)
+	assert.Equal(t, []string{"oldest"}, manager.removedImages)
+	pulled, err := pulledImages()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"sha256:pinned": true, "sha256:older": true, "sha256:newest": true, digest: true}, pulled)
+
+	readings, err := dc.Readings(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(250), readings["disk_usage"].(map[string]interface{})["free_bytes"])
+}
+
+func TestDiskSpacePreflightDisabled(t *testing.T) {
+	manager := newFakeDockerManager()
+	manager.diskUsage = &DiskUsage{FreeBytes: 0}
+	dc := newTestDockerConfig(t, manager)
+
+	err := dc.ensureImage(context.Background(), PinnedImage{ImageName: "ubuntu", RepoDigest: "sha256:abc"}, &Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, manager.pulls)
+}
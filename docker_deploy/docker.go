@@ -3,6 +3,8 @@ package docker_deploy
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
@@ -15,6 +17,13 @@ import (
 
 var Model = resource.NewModel("viam-soleng", "manage", "docker")
 
+// How often secret files are checked for changes
+const secretsPollInterval = 30 * time.Second
+
+var ErrCommandRequired = errors.New("'command' is required")
+var ErrUnknownCommand = errors.New("unknown command")
+var ErrBundlePathRequired = errors.New("'path' is required")
+
 type DockerConfig struct {
 	resource.Named
 	mu                 sync.RWMutex
@@ -23,21 +32,50 @@ type DockerConfig struct {
 	cancelFunc         func()
 	containers         []DockerContainer
 	manager            DockerManager
-	watchers           []func()
-	stop               chan bool
 	wg                 sync.WaitGroup
 	reconfigCtx        context.Context
 	reconfigCancelFunc func()
 	downloadOnly       bool
-	runOnce            bool
+	downloaded         bool
 	conf               Config
+	secrets            *resolvedSecrets
+	appliedConf        Config
+	appliedSecrets     *resolvedSecrets
+	pendingSince       time.Time
+	pendingReason      string
+	pullMu             sync.Mutex
+	pullProgress       *PullProgress
+	pullFailure        error
+	signatureStatus    map[string]interface{}
+	pullCoordinator    *pullCoordinator
+	diskMu             sync.Mutex
+	lastDiskUsage      *DiskUsage
+	lastDiskUsageAt    time.Time
+	updateMu           sync.Mutex
+	candidateDigest    string
+	updateCheckedAt    time.Time
+	updateErr          error
+	windowMu           sync.Mutex
+	windowHeld         bool
+	windowOpenUntil    time.Time
+	windowDependency   resource.Sensor
+	hostPlatform       *Platform
+	quiesceMu          sync.Mutex
+	quiesced           bool
+	probeMu            sync.Mutex
+	probes             []*probeState
+	probeCancelFunc    func()
+
+	supervisorMu         sync.Mutex
+	supervisors          []*supervisor
+	supervisorCancelFunc func()
 }
 
 func init() {
 	resource.RegisterComponent(
 		sensor.API,
 		Model,
-		resource.Registration[sensor.Sensor, *Config]{Constructor: NewDockerSensor})
+		resource.Registration[sensor.Sensor, *Config]{Constructor: NewDockerSensor, AttributeMapConverter: convertAttributes})
 }
 
 func NewDockerSensor(ctx context.Context, deps resource.Dependencies, conf resource.Config, logger logging.Logger) (sensor.Sensor, error) {
@@ -49,46 +87,150 @@ func NewDockerSensor(ctx context.Context, deps resource.Dependencies, conf resou
 		cancelCtx:  cancelCtx,
 		cancelFunc: cancelFunc,
 		mu:         sync.RWMutex{},
-		stop:       make(chan bool, 1),
 		wg:         sync.WaitGroup{},
 		containers: []DockerContainer{},
+
+		pullCoordinator: modulePullCoordinator,
 	}
 
 	if err := b.Reconfigure(ctx, deps, conf); err != nil {
 		return nil, err
 	}
+	viamutils.PanicCapturingGo(b.watchSecrets)
+	viamutils.PanicCapturingGo(b.watchUpdates)
 	return &b, nil
 }
 
-func (dc *DockerConfig) Reconfigure(ctx context.Context, _ resource.Dependencies, conf resource.Config) error {
-	dc.mu.Lock()
-	defer dc.mu.Unlock()
+func (dc *DockerConfig) Reconfigure(ctx context.Context, deps resource.Dependencies, conf resource.Config) error {
 	dc.logger.Debug("Reconfiguring Docker Manager Module")
 
 	newConf, err := resource.NativeConfig[*Config](conf)
 	if err != nil {
 		return err
 	}
+	var dependency resource.Sensor
+	if newConf.MaintenanceWindow != nil && newConf.MaintenanceWindow.Dependency != nil {
+		if dependency, err = windowDependencyFrom(deps, newConf.MaintenanceWindow.Dependency.Resource); err != nil {
+			return err
+		}
+	}
+	// The dependency's Readings can take up to windowDependencyTimeout, so the window is checked before taking dc.mu
+	open, reason := dc.maintenanceWindowOpenWith(newConf, dependency, time.Now())
+	window := windowStatus{open: open, reason: reason}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	// The containers of the old runtime are stopped by closing the component, viam-server then creates it again
+	if dc.manager != nil && newConf.runtime() != dc.conf.runtime() {
+		return resource.NewMustRebuildError(conf.ResourceName())
+	}
+	// Templates are expanded once, the rest of the module works with the values the containers are created with
+	newConf, templateErrors := newConf.withTemplates(newConf.Templates.lookup(conf.ResourceName().ShortName()))
+	if len(templateErrors) > 0 {
+		return errors.Join(templateErrors...)
+	}
+	newConf = dc.pinTag(newConf)
+	if newConf, err = dc.pinPlatformDigest(ctx, newConf); err != nil {
+		return err
+	}
 	defer func() {
 		dc.conf = *newConf
 	}()
 
 	// In case the module has changed name
+	previousName := dc.Name().String()
 	dc.Named = conf.ResourceName().AsNamed()
 
-	return dc.reconfigure(newConf)
+	// Two components publishing the same host port would leave one of them failing to start
+	previousPorts := modulePorts.ports(previousName)
+	if err := modulePorts.reserve(dc.Name().String(), newConf); err != nil {
+		return err
+	}
+	if previousName != dc.Name().String() {
+		modulePorts.remove(previousName)
+		moduleImages.remove(previousName)
+	}
+
+	dc.windowMu.Lock()
+	dc.windowDependency = dependency
+	dc.windowMu.Unlock()
+
+	if err := dc.reconfigure(newConf, window); err != nil {
+		// Nothing publishes the new ports, only those of the containers that are still running stay reserved
+		modulePorts.set(dc.Name().String(), previousPorts)
+		return err
+	}
+	return nil
 }
 
-// A helper function to reconfigure the module, broken out from Reconfigure to make testing easier.
-func (dc *DockerConfig) reconfigure(newConf *Config) error {
+// A helper function to reconfigure the module, broken out from Reconfigure to make testing easier. The maintenance
+// window is checked by the caller before dc.mu is taken.
+func (dc *DockerConfig) reconfigure(newConf *Config, window windowStatus) error {
 	// Check if the image exists already?
 	// If image exists and is running, return
 	// If image exists and is not running, start it.
 	// If image does not exist, pull it
 	// Start image
 
+	// Secrets are read from their files on every reconfigure, and again by watchSecrets when the files change
+	secrets, err := resolveSecrets(newConf)
+	if err != nil {
+		return err
+	}
+
+	auth := NewRegistryAuth(secrets.credentials, newConf.DockerConfigPath)
+	if err := dc.ensureManager(newConf, auth); err != nil {
+		return err
+	}
+	startVolumeJanitor(newConf.runtime(), dc.manager, dc.logger)
+	// Always refresh the credentials, so rotated passwords are used by the next pull
+	dc.manager.SetRegistryAuth(auth)
+
+	// Garbage collection of unused images must not remove an image any component is configured with
+	if images, err := pinnedImages(newConf); err == nil {
+		moduleImages.set(dc.Name().String(), images)
+	}
+
+	// The running containers are stopped the way the config they were created from says
+	previousConf := dc.appliedConf
+
+	// Changes are compared with the config the containers were created from, which is behind dc.conf while an
+	// update is waiting for the maintenance window
+	containersChanged := dc.appliedConf.ContainersChanged(newConf) || !dc.appliedSecrets.envEqual(secrets)
+	credentialsChanged := dc.appliedConf.CredentialsChanged(newConf) || !dc.appliedSecrets.credentialsEqual(secrets)
+	probesChanged := !reflect.DeepEqual(dc.appliedConf.Probes, newConf.Probes)
+	dc.secrets = secrets
+
+	// Recreating running containers interrupts whatever they are doing, so it waits for the maintenance window
+	if containersChanged && len(dc.containers) > 0 {
+		if !window.open {
+			if dc.pendingSince.IsZero() {
+				dc.pendingSince = time.Now()
+				dc.logger.Infof("Deferring the update to %s@%s until the maintenance window opens: %s", newConf.ImageName, newConf.RepoDigest, window.reason)
+			}
+			dc.pendingReason = window.reason
+			return nil
+		}
+	}
+	dc.pendingSince = time.Time{}
+	dc.pendingReason = ""
+	dc.appliedConf = *newConf
+	dc.appliedSecrets = secrets
+
+	// Probes don't need the containers to be recreated, new containers get them once they are created
+	if probesChanged && !containersChanged && len(dc.containers) > 0 {
+		dc.startProbes(newConf.Probes)
+	}
+
 	// Let's try to be efficient and only make changes if changes happened.
-	if !dc.conf.HasChanged(newConf) {
+	if !containersChanged && !credentialsChanged {
+		return nil
+	}
+
+	// If only the credentials changed, the containers can stay as they are. We only need to retry the
+	// download if it never finished, e.g. because the old credentials were wrong.
+	if !containersChanged && (len(dc.containers) > 0 || dc.downloadOnly) && dc.imagesPresent(newConf) {
+		dc.logger.Info("Registry credentials changed, containers are up to date")
 		return nil
 	}
 
@@ -98,31 +240,20 @@ func (dc *DockerConfig) reconfigure(newConf *Config) error {
 
 	dc.reconfigCtx, dc.reconfigCancelFunc = context.WithCancel(dc.cancelCtx)
 
-	if dc.manager == nil {
-		if newConf.Credentials != nil {
-			manager, err := NewLocalDockerManagerWithAuth(newConf.Credentials.Username, newConf.Credentials.Password, dc.logger)
-			if err != nil {
-				return err
-			}
-			dc.manager = manager
-		} else {
-			manager, err := NewLocalDockerManager(dc.logger)
-			if err != nil {
-				return err
-			}
-			dc.manager = manager
-		}
-	}
-
 	// Close the existing containers, remove it, and set it to nil
 	// Should download the new image before stopping the old one
+	dc.stopProbes()
+	dc.stopSupervisors()
 	if len(dc.containers) > 0 {
 		for _, container := range dc.containers {
-			dc.manager.StopContainer(container.GetContainerId())
+			dc.stopContainer(&previousConf, container.GetContainerId())
 			dc.manager.RemoveContainer(container.GetContainerId())
 		}
 		dc.containers = []DockerContainer{}
 	}
+	// The old containers are gone, so networks only they used can be removed
+	dc.releaseNetworks(moduleNetworks.set(dc.Name().String(), networkNames(newConf)))
+	moduleVolumes.set(dc.Name().String(), volumeNames(newConf))
 
 	// TODO: Cleanup old images
 	// Possibly tag images with the component name that uses them?
@@ -131,46 +262,239 @@ func (dc *DockerConfig) reconfigure(newConf *Config) error {
 	// I'm not a huge fan of this functionality, it feels like we're using the wrong tool for
 	// the job, but it's what we have for now.
 	dc.downloadOnly = newConf.DownloadOnly
+	dc.downloaded = false
 
-	// Make sure we track if the image is run once only
-	dc.runOnce = newConf.RunOnce
-
-	viamutils.PanicCapturingGo(func() { dc.startDownload(newConf) })
+	resolvedConf := newConf.withSecrets(secrets)
+	reconfigCtx := dc.reconfigCtx
+	viamutils.PanicCapturingGo(func() { dc.startDownload(reconfigCtx, resolvedConf) })
 
 	return nil
 }
 
-func (dc *DockerConfig) startDownload(newConf *Config) {
-	// Check if the image exists locally already
-	imageExists, err := dc.manager.ImageExists(newConf.RepoDigest)
+// watchSecrets periodically re-reads the secret files and reconfigures if their contents have changed
+func (dc *DockerConfig) watchSecrets() {
+	for {
+		select {
+		case <-dc.cancelCtx.Done():
+			return
+		case <-time.After(secretsPollInterval):
+		}
+
+		dc.mu.RLock()
+		hasSecretFiles := dc.conf.hasSecretFiles()
+		dc.mu.RUnlock()
+		if !hasSecretFiles {
+			continue
+		}
+		window := dc.currentWindow()
+
+		dc.mu.Lock()
+		if dc.cancelCtx.Err() == nil && dc.conf.hasSecretFiles() {
+			conf := dc.conf
+			if err := dc.reconfigure(&conf, window); err != nil {
+				dc.logger.Warnf("Unable to refresh secrets: %v", err)
+			}
+		}
+		dc.mu.Unlock()
+	}
+}
+
+func (dc *DockerConfig) startDownload(ctx context.Context, newConf *Config) {
+	dc.setPullFailure(nil)
+
+	// The tag is resolved first, applying its digest reconfigures the component which downloads it
+	if newConf.Tag != "" && newConf.RepoDigest == "" {
+		dc.resolveTag(ctx, newConf)
+		return
+	}
+
+	// Compose files can reference images from other registries, each of them is pulled with its own credentials
+	images, err := pinnedImages(newConf)
 	if err != nil {
 		dc.logger.Error(err)
 		return
 	}
-	// If the image doesn't exist, pull it
-	if !imageExists {
-		dc.logger.Infof("Image %s does not exist. Pulling...", newConf.ImageName)
-		err := dc.manager.PullImage(dc.reconfigCtx, newConf.ImageName, newConf.RepoDigest)
-		if err != nil {
-			dc.logger.Error(err)
+	for _, image := range images {
+		if err := dc.ensureImage(ctx, image, newConf); err != nil {
+			if ctx.Err() == nil {
+				dc.logger.Errorf("Giving up on pulling %s: %v", image, err)
+				dc.setPullFailure(err)
+			}
 			return
 		}
 	}
+	// Signatures are checked against what is local now, whether it was pulled, loaded from a bundle or already there
+	if err := dc.verifyImages(ctx, images, newConf); err != nil {
+		if ctx.Err() == nil {
+			dc.logger.Errorf("Refusing to start %s: %v", newConf.ImageName, err)
+			dc.setPullFailure(err)
+		}
+		return
+	}
+
+	dc.finishReconfigure(ctx, newConf)
+}
+
+// imagesPresent reports whether every image needed by the config is present locally
+func (dc *DockerConfig) imagesPresent(conf *Config) bool {
+	images, err := pinnedImages(conf)
+	if err != nil {
+		return false
+	}
+	for _, image := range images {
+		exists, err := dc.manager.ImageExists(image.RepoDigest)
+		if err != nil || !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureImage makes sure the image is present locally, loading it from a bundle or pulling it
+func (dc *DockerConfig) ensureImage(ctx context.Context, image PinnedImage, conf *Config) error {
+	// Check if the image exists locally already
+	imageExists, err := dc.manager.ImageExists(image.RepoDigest)
+	if err != nil {
+		return err
+	}
+	// A bundle can't prove its images are the ones the registry signed, so enforced verification pulls them instead
+	enforced := conf.Verify != nil && conf.Verify.Enforce
+	if imageExists && enforced && loadedFromBundle(image.RepoDigest) {
+		dc.logger.Infof("Image %s was loaded from a bundle, pulling it because verify.enforce is set", image)
+		imageExists = false
+	}
+	// If the image doesn't exist, try to load it from a bundle before pulling it
+	if !imageExists && !enforced {
+		loaded, err := dc.loadImageFromBundles(ctx, image)
+		if err != nil {
+			dc.logger.Warnf("Unable to load image %s from bundle: %v", image.ImageName, err)
+		}
+		imageExists = loaded
+	}
+	// If the image still doesn't exist, pull it
+	if !imageExists {
+		dc.logger.Infof("Image %s does not exist. Pulling...", image.ImageName)
+		if err := dc.checkDiskSpace(ctx, image, conf); err != nil {
+			return err
+		}
+		if err := dc.pullWithRetry(ctx, image, conf); err != nil {
+			return err
+		}
+		dc.recordPulledImages(image.RepoDigest)
+		// The pulled image has the digest itself, the offline tag of a bundle is no longer needed
+		if err := updateLoadedImages(map[string]string{image.RepoDigest: ""}); err != nil {
+			dc.logger.Warnf("Unable to forget that %s was loaded from a bundle: %v", image, err)
+		}
+	}
+	return dc.checkImagePlatform(ctx, image)
+}
+
+// pullWithRetry pulls the image, retrying with exponential backoff. The daemon keeps the layers that finished
+// downloading, so each attempt only downloads what is still missing. Pulls go through the module wide coordinator,
+// which limits concurrent pulls and shares a pull of the same digest between components.
+func (dc *DockerConfig) pullWithRetry(ctx context.Context, image PinnedImage, conf *Config) error {
+	maxAttempts, backoff, maxBackoff := conf.PullRetry.get()
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		progress := NewPullProgress(image.String(), dc.logger)
+		progress.setAttempt(attempt, maxAttempts)
+
+		err = dc.pullCoordinator.pull(ctx, pullKey(image, conf), conf.PullPriority, progress, dc.setPullProgress, func(ctx context.Context, progress *PullProgress) error {
+			return dc.manager.PullImage(ctx, image.ImageName, image.RepoDigest, progress)
+		})
+		if err == nil || ctx.Err() != nil || attempt == maxAttempts {
+			break
+		}
+
+		dc.logger.Warnf("Pulling %s failed (attempt %d of %d), retrying in %s: %v", image, attempt, maxAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+	return err
+}
+
+func (dc *DockerConfig) setPullFailure(err error) {
+	dc.pullMu.Lock()
+	defer dc.pullMu.Unlock()
+	dc.pullFailure = err
+}
+
+// failureState names the state of a component whose images couldn't be made ready
+func failureState(err error) string {
+	if errors.Is(err, ErrVerificationFailed) {
+		return "verification_failed"
+	}
+	return "pull_failed"
+}
+
+func (dc *DockerConfig) getPullFailure() error {
+	dc.pullMu.Lock()
+	defer dc.pullMu.Unlock()
+	return dc.pullFailure
+}
 
-	dc.finishReconfigure(newConf)
+func (dc *DockerConfig) setPullProgress(progress *PullProgress) {
+	dc.pullMu.Lock()
+	defer dc.pullMu.Unlock()
+	dc.pullProgress = progress
+}
+
+// pullStatus returns the status of the most recent pull, or nil if nothing has been pulled
+func (dc *DockerConfig) pullStatus() map[string]interface{} {
+	dc.pullMu.Lock()
+	progress := dc.pullProgress
+	dc.pullMu.Unlock()
+	if progress == nil {
+		return nil
+	}
+	return progress.Status()
 }
 
-func (dc *DockerConfig) finishReconfigure(newConf *Config) {
+func (dc *DockerConfig) finishReconfigure(ctx context.Context, newConf *Config) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	// A later reconfigure or Close took over while the image was downloading
+	if ctx.Err() != nil {
+		return
+	}
+	dc.downloaded = true
+	// Containers left behind by an earlier instance of the component, e.g. with on_close leave_running, are replaced
+	dc.removePreviousContainers(ctx, newConf)
 	if !dc.downloadOnly {
+		// Containers write to their own layer, keep min_free_bytes free for them and for viam-server
+		if err := dc.ensureFreeSpace(ctx, newConf.MinFreeBytes, newConf); err != nil {
+			dc.logger.Error(err)
+			return
+		}
 		if newConf.ComposeOptions != nil {
-			containers, err := dc.manager.CreateComposeContainers(newConf.ImageName, newConf.RepoDigest, newConf.ComposeOptions.ComposeFile, dc.logger, dc.reconfigCtx)
+			if err := dc.ensureNetworks(ctx, newConf); err != nil {
+				dc.logger.Error(err)
+				return
+			}
+			if err := dc.ensureVolumes(ctx, newConf); err != nil {
+				dc.logger.Error(err)
+				return
+			}
+			containers, err := dc.manager.CreateComposeContainers(newConf.ImageName, newConf.RepoDigest, newConf.ComposeOptions.ComposeFile, dc.containerLabels(), dc.logger, ctx)
 			if err != nil {
 				dc.logger.Error(err)
 				return
 			}
 			dc.containers = containers
 		} else if newConf.RunOptions != nil {
-			container, err := dc.manager.CreateContainer(newConf.ImageName, newConf.RepoDigest, newConf.RunOptions.EntryPointArgs, newConf.RunOptions.Options, newConf.RunOptions.HostOptions, dc.logger, dc.reconfigCtx)
+			if err := dc.ensureNetworks(ctx, newConf); err != nil {
+				dc.logger.Error(err)
+				return
+			}
+			if err := dc.ensureVolumes(ctx, newConf); err != nil {
+				dc.logger.Error(err)
+				return
+			}
+			container, err := dc.manager.CreateContainer(newConf.ImageName, newConf.RepoDigest, newConf.RunOptions, dc.containerLabels(), dc.logger, ctx)
 			if err != nil {
 				dc.logger.Error(err)
 				return
@@ -184,40 +508,9 @@ func (dc *DockerConfig) finishReconfigure(newConf *Config) {
 		}
 	}
 
-	if dc.watchers == nil {
-		dc.watchers = make([]func(), len(dc.containers))
-		for i, container := range dc.containers {
-			dc.watchers[i] = func() {
-				dc.wg.Add(1)
-				defer dc.wg.Done()
-				for {
-					select {
-					case <-dc.cancelCtx.Done():
-						dc.logger.Info("received cancel signal")
-						return
-					case <-dc.stop:
-						dc.logger.Info("received stop signal")
-						return
-					case <-time.After(10 * time.Second):
-						dc.logger.Debug("iterating...")
-					}
-
-					dc.logger.Debug("image exists. Checking if running...")
-					isRunning, err := container.IsRunning()
-					if err != nil {
-						dc.logger.Error(err)
-						continue
-					}
-					if !isRunning && dc.shouldRun() {
-						dc.logger.Debug("container not running. Starting...")
-						dc.startInternal()
-					} else {
-						dc.logger.Debug("container run conditions satisfied. Sleeping...")
-					}
-				}
-			}
-			viamutils.PanicCapturingGo(dc.watchers[i])
-		}
+	if !dc.downloadOnly {
+		dc.startProbes(newConf.Probes)
+		dc.superviseContainers(ctx, dc.containers, newConf.RunOnce)
 	}
 }
 
@@ -234,17 +527,30 @@ func (dc *DockerConfig) getReadings(container DockerContainer) (map[string]inter
 	if err != nil {
 		return nil, err
 	}
-	return map[string]interface{}{
+	readings := map[string]interface{}{
 		"repoDigest":  container.GetRepoDigest(),
 		"ImageName":   dc.conf.ImageName,
 		"imageId":     imageId,
 		"containerId": container.GetContainerId(),
 		"isRunning":   isRunning,
-	}, nil
+	}
+	health, err := container.GetHealth()
+	if err != nil {
+		return nil, err
+	}
+	if health.Status != "" {
+		readings["health"] = health.readings()
+	}
+	return readings, nil
 }
 
 // Readings implements sensor.Sensor.
 func (dc *DockerConfig) Readings(ctx context.Context, extra map[string]interface{}) (map[string]interface{}, error) {
+	dc.mu.RLock()
+	conf := dc.conf
+	pendingUpdate := dc.pendingUpdateStatus()
+	dc.mu.RUnlock()
+
 	resp := map[string]interface{}{}
 	for _, container := range dc.containers {
 		readings, err := dc.getReadings(container)
@@ -252,75 +558,210 @@ func (dc *DockerConfig) Readings(ctx context.Context, extra map[string]interface
 			dc.logger.Error(err)
 			continue
 		}
-		println("-----", readings)
-		println("-----", readings["imageId"] == dc.conf.RepoDigest, readings["imageId"], dc.conf.RepoDigest)
-		if readings["imageId"] == dc.conf.RepoDigest {
-			return readings, nil
-		} else {
-			resp = readings
+		resp = readings
+		if readings["imageId"] == conf.RepoDigest {
+			break
 		}
 	}
+	if pullStatus := dc.pullStatus(); pullStatus != nil {
+		resp["pull_status"] = pullStatus
+	}
+	if pendingUpdate != nil {
+		resp["pending_update"] = pendingUpdate
+	}
+	if updateStatus := dc.updateStatus(&conf); updateStatus != nil {
+		resp["update"] = updateStatus
+	}
+	if diskUsage, err := dc.diskUsageStatus(ctx); err != nil {
+		dc.logger.Warnf("Unable to get disk usage: %v", err)
+	} else {
+		resp["disk_usage"] = diskUsage
+	}
+	if probes := dc.probeReadings(); probes != nil {
+		resp["probes"] = probes
+	}
+	if signatureStatus := dc.getSignatureStatus(); signatureStatus != nil {
+		resp["signature"] = signatureStatus
+	}
+	if err := dc.getPullFailure(); err != nil {
+		state := failureState(err)
+		resp["state"] = state
+		resp[state+"_reason"] = err.Error()
+	}
 	return resp, nil
 }
 
+// DoCommand implements resource.Resource.
+func (dc *DockerConfig) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	command, ok := cmd["command"].(string)
+	if !ok {
+		return nil, ErrCommandRequired
+	}
+
+	switch command {
+	case "export_bundle":
+		return dc.doExportBundle(ctx, cmd)
+	case "import_bundle":
+		return dc.doImportBundle(ctx, cmd)
+	case "check_update":
+		return dc.doCheckUpdate(ctx, false)
+	case "apply_update":
+		return dc.doCheckUpdate(ctx, true)
+	case "maintenance_window":
+		return dc.doMaintenanceWindow(cmd)
+	case "backup_volume":
+		return dc.doBackupVolume(ctx, cmd)
+	case "restore_volume":
+		return dc.doRestoreVolume(ctx, cmd)
+	case "pull_status":
+		if pullStatus := dc.pullStatus(); pullStatus != nil {
+			return pullStatus, nil
+		}
+		return map[string]interface{}{"state": "idle"}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+	}
+}
+
+// doExportBundle handles {"command": "export_bundle", "name": "optional-bundle-name"}
+func (dc *DockerConfig) doExportBundle(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	dc.mu.RLock()
+	conf := dc.conf
+	dc.mu.RUnlock()
+
+	name, _ := cmd["name"].(string)
+	bundlePath, manifest, err := dc.exportBundle(ctx, &conf, name)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"path":    bundlePath,
+		"images":  len(manifest.Images),
+		"volumes": len(manifest.Volumes),
+	}, nil
+}
+
+// doImportBundle handles {"command": "import_bundle", "path": "bundle.tar", "restore_volumes": true}
+func (dc *DockerConfig) doImportBundle(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	path, ok := cmd["path"].(string)
+	if !ok || path == "" {
+		return nil, ErrBundlePathRequired
+	}
+	bundlePath, err := resolveBundlePath(path)
+	if err != nil {
+		return nil, err
+	}
+	restoreVolumes := true
+	if v, ok := cmd["restore_volumes"].(bool); ok {
+		restoreVolumes = v
+	}
+
+	manifest, err := dc.importBundle(ctx, bundlePath, restoreVolumes)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"path":    bundlePath,
+		"images":  len(manifest.Images),
+		"volumes": len(manifest.Volumes),
+	}, nil
+}
+
 func (dc *DockerConfig) Close(ctx context.Context) error {
 	dc.mu.Lock()
 	defer dc.mu.Unlock()
 	dc.logger.Debug("Closing Docker Manager Module")
-	dc.stop <- true
+	// Cancelling the context stops the supervisors, probes and any pull in progress. The supervisors are waited on
+	// before the containers are stopped so they can't start them again.
+	dc.cancelFunc()
+	dc.stopProbes()
+	dc.stopSupervisors()
+	moduleImages.remove(dc.Name().String())
+	modulePorts.remove(dc.Name().String())
+
+	onClose := dc.appliedConf.onClose()
+	if onClose == OnCloseLeaveRunning {
+		// The running containers still use their networks and volumes, so they stay registered to the component
+		dc.logger.Infof("Leaving %d containers running", len(dc.containers))
+		return nil
+	}
 	for _, container := range dc.containers {
-		if container != nil {
-			dc.logger.Debugf("Stopping container %v", container.GetContainerId())
-			err := dc.manager.StopContainer(container.GetContainerId())
-			if err != nil {
+		if container == nil {
+			continue
+		}
+		id := container.GetContainerId()
+		dc.logger.Debugf("Stopping container %v", id)
+		if err := dc.stopContainer(&dc.appliedConf, id); err != nil {
+			dc.logger.Error(err)
+		}
+		if onClose == OnCloseRemove {
+			dc.logger.Debugf("Removing container %v", id)
+			if err := dc.manager.RemoveContainer(id); err != nil {
 				dc.logger.Error(err)
 			}
 		}
 	}
-	dc.logger.Debug("Stop command sent, waiting on WaitGroup")
-	dc.wg.Wait()
+	dc.releaseNetworks(moduleNetworks.remove(dc.Name().String()))
+	moduleVolumes.remove(dc.Name().String())
 	return nil
 }
 
 func (dc *DockerConfig) Ready(ctx context.Context, extra map[string]interface{}) (bool, error) {
-	isRunning := false
-	for _, container := range dc.containers {
-		h, err := container.IsRunning()
-		if err != nil {
-			dc.logger.Error(err)
-		}
-		isRunning = isRunning && h
+	if err := dc.getPullFailure(); err != nil {
+		return false, fmt.Errorf("%s: %w", failureState(err), err)
 	}
-	return isRunning, nil
-}
-
-func (dc *DockerConfig) shouldRun() bool {
-	// If the image is only configured to be downloaded, we don't want to start it
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	// There are no containers to wait for, the component is ready once its images are downloaded and verified
 	if dc.downloadOnly {
-		return false
+		return dc.downloaded, nil
 	}
-	// If the image should run once only, we don't want to start it if it has already run
-	hasRun := false
+	if len(dc.containers) == 0 {
+		return false, nil
+	}
+	// Every container has to be running, and healthy if it has a healthcheck. A run_once container that exited
+	// successfully has done its job.
+	running := 0
 	for _, container := range dc.containers {
-		h, err := container.GetHasRun()
+		isRunning, err := container.IsRunning()
+		if err != nil {
+			dc.logger.Error(err)
+			return false, nil
+		}
+		if !isRunning {
+			if !dc.appliedConf.RunOnce || !dc.finishedSuccessfully(container) {
+				return false, nil
+			}
+			continue
+		}
+		running++
+		health, err := container.GetHealth()
 		if err != nil {
 			dc.logger.Error(err)
+			return false, nil
+		}
+		if !health.healthy() {
+			return false, nil
 		}
-		hasRun = hasRun && h
 	}
-	if (dc.runOnce && !hasRun) || !dc.runOnce {
-		return true
+	// Probes can't pass once every container has finished
+	if running == 0 {
+		return true, nil
 	}
-	return false
+	return dc.probesPassing(), nil
 }
 
-func (dc *DockerConfig) startInternal() {
-	for _, container := range dc.containers {
-		dc.logger.Debug("Starting container %v", container.GetContainerId())
-		err := dc.manager.StartContainer(container.GetContainerId())
-		if err != nil {
-			dc.logger.Error(err)
-		}
-		container.SetHasRun()
+// finishedSuccessfully reports whether a run_once container has run and exited with status 0
+func (dc *DockerConfig) finishedSuccessfully(container DockerContainer) bool {
+	hasRun, err := container.GetHasRun()
+	if err != nil {
+		dc.logger.Error(err)
+		return false
+	}
+	exitCode, err := container.GetExitCode()
+	if err != nil {
+		dc.logger.Error(err)
+		return false
 	}
+	return hasRun && exitCode == 0
 }
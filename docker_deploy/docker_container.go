@@ -11,39 +11,55 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/docker/docker/client"
 	"go.viam.com/rdk/logging"
 )
 
 type DockerContainer interface {
 	IsRunning() (bool, error)
+	GetHealth() (*ContainerHealth, error)
 	GetHasRun() (bool, error)
 	SetHasRun() error
+	GetExitCode() (int, error)
 	GetContainerId() string
 	GetImageId() (string, error)
 	GetRepoDigest() string
 }
 
+// ContainerInspector is the part of the runtime's API a container needs. Each runtime converts its own inspect result,
+// so containers don't depend on the types of Docker's API.
+type ContainerInspector interface {
+	InspectContainer(ctx context.Context, containerId string) (*ContainerInspect, error)
+}
+
+// ContainerInspect is the state of a container as reported by its runtime
+type ContainerInspect struct {
+	ID       string
+	ImageID  string
+	Running  bool
+	ExitCode int
+	Health   *ContainerHealth
+}
+
 type LocalDockerContainer struct {
-	mu           sync.RWMutex
-	cancelCtx    context.Context
-	logger       logging.Logger
-	dockerClient *client.Client
+	mu        sync.RWMutex
+	cancelCtx context.Context
+	logger    logging.Logger
+	inspector ContainerInspector
 
 	Id         string
 	Name       string
 	RepoDigest string
 }
 
-func NewDockerContainer(dockerClient *client.Client, containerId string, name string, repoDigest string, logger logging.Logger, cancelCtx context.Context) DockerContainer {
+func NewDockerContainer(inspector ContainerInspector, containerId string, name string, repoDigest string, logger logging.Logger, cancelCtx context.Context) DockerContainer {
 	return &LocalDockerContainer{
-		mu:           sync.RWMutex{},
-		logger:       logger,
-		cancelCtx:    cancelCtx,
-		dockerClient: dockerClient,
-		Id:           containerId,
-		Name:         name,
-		RepoDigest:   repoDigest,
+		mu:         sync.RWMutex{},
+		logger:     logger,
+		cancelCtx:  cancelCtx,
+		inspector:  inspector,
+		Id:         containerId,
+		Name:       name,
+		RepoDigest: repoDigest,
 	}
 }
 
@@ -51,13 +67,31 @@ func (di *LocalDockerContainer) IsRunning() (bool, error) {
 	di.mu.Lock()
 	defer di.mu.Unlock()
 	di.logger.Debugf("Checking if container %s Image %s %s is running", di.Id, di.Name, di.RepoDigest)
-	container, err := di.dockerClient.ContainerInspect(context.Background(), di.Id)
+	container, err := di.inspector.InspectContainer(context.Background(), di.Id)
 	if err != nil {
 		return false, err
 	}
 
-	di.logger.Debugf("containerId: %v isRunning: %v", container.ID, container.State.Running)
-	return container.State.Running, nil
+	di.logger.Debugf("containerId: %v isRunning: %v", container.ID, container.Running)
+	return container.Running, nil
+}
+
+// GetExitCode returns the exit code of the container's last run, 0 if it never ran
+func (di *LocalDockerContainer) GetExitCode() (int, error) {
+	container, err := di.inspector.InspectContainer(context.Background(), di.Id)
+	if err != nil {
+		return 0, err
+	}
+	return container.ExitCode, nil
+}
+
+// GetHealth returns the state of the container's healthcheck
+func (di *LocalDockerContainer) GetHealth() (*ContainerHealth, error) {
+	container, err := di.inspector.InspectContainer(context.Background(), di.Id)
+	if err != nil {
+		return nil, err
+	}
+	return container.Health, nil
 }
 
 func (di *LocalDockerContainer) GetContainerId() string {
@@ -65,13 +99,13 @@ func (di *LocalDockerContainer) GetContainerId() string {
 }
 
 func (di *LocalDockerContainer) GetImageId() (string, error) {
-	container, err := di.dockerClient.ContainerInspect(context.Background(), di.Id)
+	container, err := di.inspector.InspectContainer(context.Background(), di.Id)
 	if err != nil {
 		return "", err
 	}
 
-	di.logger.Debugf("containerId: %v imageId: %v", container.ID, container.Image)
-	return container.Image, nil
+	di.logger.Debugf("containerId: %v imageId: %v", container.ID, container.ImageID)
+	return container.ImageID, nil
 }
 
 func (di *LocalDockerContainer) GetRepoDigest() string {
@@ -154,10 +188,19 @@ func (di *LocalDockerContainer) SetHasRun() error {
 	return nil
 }
 
-func getHasRunStatusFileHandle() (*os.File, error) {
+// moduleDataDirectory returns the module's data directory, set by viam-server through VIAM_MODULE_DATA
+func moduleDataDirectory() (string, error) {
 	moduleDirectory := os.Getenv("VIAM_MODULE_DATA")
 	if moduleDirectory == "" {
-		return nil, errors.New("VIAM_MODULE_DATA is not set")
+		return "", errors.New("VIAM_MODULE_DATA is not set")
+	}
+	return moduleDirectory, nil
+}
+
+func getHasRunStatusFileHandle() (*os.File, error) {
+	moduleDirectory, err := moduleDataDirectory()
+	if err != nil {
+		return nil, err
 	}
 
 	hasRunFilePath := fmt.Sprintf("%s/%s", moduleDirectory, "has-run.status")
@@ -1,21 +1,32 @@
 package docker_deploy
 
 import (
+	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/compose-spec/compose-go/loader"
 	compose_types "github.com/compose-spec/compose-go/types"
+	"github.com/distribution/reference"
 	docker_types "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"go.viam.com/rdk/logging"
 )
@@ -23,10 +34,14 @@ import (
 var ErrImageNotFound = errors.New("image not found")
 var ErrContainerNotFound = errors.New("container not found")
 
+// The path volumes are mounted at in the helper containers used to export and import them
+const volumeHelperMountPath = "/viam-volume"
+
 type DockerManager interface {
 	ListContainers() ([]DockerContainerDetails, error)
-	CreateContainer(imageName string, repoDigest string, entry_point_args []string, options map[string]interface{}, host_options map[string]interface{}, logger logging.Logger, cancelCtx context.Context) (DockerContainer, error)
-	CreateComposeContainers(imageName string, repoDigest string, composeFile []string, logger logging.Logger, cancelCtx context.Context) ([]DockerContainer, error)
+	CreateContainer(imageName string, repoDigest string, runOptions *RunOptions, labels map[string]string, logger logging.Logger, cancelCtx context.Context) (DockerContainer, error)
+	CreateComposeContainers(imageName string, repoDigest string, composeFile []string, labels map[string]string, logger logging.Logger, cancelCtx context.Context) ([]DockerContainer, error)
+	ListLabeledContainers(ctx context.Context, labels map[string]string) ([]string, error)
 
 	ListImages() ([]DockerImageDetails, error)
 	GetImageDetails(imageId string) (*DockerImageDetails, error)
@@ -34,21 +49,52 @@ type DockerManager interface {
 	GetContainerImageDigest(containerId string) (string, error)
 	GetContainersRunningImage(imageDigest string) ([]DockerContainerDetails, error)
 
-	PullImage(ctx context.Context, imageName string, repoDigest string) error
+	PullImage(ctx context.Context, imageName string, repoDigest string, progress *PullProgress) error
 	ImageExists(repoDigest string) (bool, error)
 	RemoveImageByImageId(imageId string) error
 	RemoveImageByRepoDigest(repoDigest string) error
+	SaveImages(ctx context.Context, images []PinnedImage, w io.Writer) error
+	LoadImages(ctx context.Context, r io.Reader) error
+	OfflineImageID(ctx context.Context, image PinnedImage) (string, error)
+
+	ExportVolume(ctx context.Context, volumeName string, helperImage PinnedImage, w io.Writer) error
+	ImportVolume(ctx context.Context, volumeName string, helperImage PinnedImage, r io.Reader) error
+
+	SetRegistryAuth(auth *RegistryAuth)
+	DiskUsage(ctx context.Context) (*DiskUsage, error)
+	HostPlatform(ctx context.Context) (Platform, error)
+	ImagePlatform(ctx context.Context, image PinnedImage) (Platform, error)
 
+	EnsureNetwork(ctx context.Context, options *NetworkOptions) error
+	RemoveNetwork(ctx context.Context, name string) error
+	EnsureVolume(ctx context.Context, options *VolumeOptions, labels map[string]string) error
+	ListManagedVolumes(ctx context.Context) ([]ManagedVolume, error)
+	RemoveVolume(ctx context.Context, name string) error
+
+	ContainerAddress(ctx context.Context, containerId string, port int) (string, error)
 	StartContainer(containerId string) error
-	StopContainer(containerId string) error
+	StopContainer(containerId string, options container.StopOptions) error
+	ExecContainer(ctx context.Context, containerId string, cmd []string) (int, string, error)
+	PauseContainer(containerId string) error
+	UnpauseContainer(containerId string) error
 	RemoveContainer(containerId string) error
 }
 
 type LocalDockerManager struct {
+	mu           sync.RWMutex
 	logger       logging.Logger
-	dockerClient *client.Client
-	username     string
-	password     string
+	dockerClient client.APIClient
+	auth         *RegistryAuth
+}
+
+// PinnedImage is an image referenced by name and repo digest, the way images are configured for this module.
+type PinnedImage struct {
+	ImageName  string `json:"image_name"`
+	RepoDigest string `json:"repo_digest"`
+}
+
+func (pi PinnedImage) String() string {
+	return fmt.Sprintf("%s@%s", pi.ImageName, pi.RepoDigest)
 }
 
 type DockerImageDetails struct {
@@ -70,14 +116,22 @@ type DockerContainerDetails struct {
 	Names       string
 }
 
-func NewLocalDockerManagerWithAuth(username string, password string, logger logging.Logger) (DockerManager, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
-	return &LocalDockerManager{logger: logger, dockerClient: cli, username: username, password: password}, err
+func NewLocalDockerManagerWithAuth(auth *RegistryAuth, logger logging.Logger) (DockerManager, error) {
+	return NewLocalDockerManagerForRuntime(RuntimeDocker, auth, logger)
 }
 
+// NewLocalDockerManagerForRuntime creates a manager for the containers of a runtime, docker or podman
+func NewLocalDockerManagerForRuntime(runtime string, auth *RegistryAuth, logger logging.Logger) (DockerManager, error) {
+	cli, err := newRuntimeClient(runtime)
+	if auth != nil {
+		auth.logger = logger
+	}
+	return &LocalDockerManager{logger: logger, dockerClient: cli, auth: auth}, err
+}
+
+// NewLocalDockerManager creates a manager that authenticates pulls using the default Docker config.json, like the docker CLI
 func NewLocalDockerManager(logger logging.Logger) (DockerManager, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
-	return &LocalDockerManager{logger: logger, dockerClient: cli}, err
+	return NewLocalDockerManagerWithAuth(NewRegistryAuth(nil, ""), logger)
 }
 
 func (dm *LocalDockerManager) ListImages() ([]DockerImageDetails, error) {
@@ -86,23 +140,32 @@ func (dm *LocalDockerManager) ListImages() ([]DockerImageDetails, error) {
 		return nil, err
 	}
 	var images []DockerImageDetails
+	loaded, err := loadedImages()
+	if err != nil {
+		dm.logger.Warnf("Unable to read the images loaded from bundles: %v", err)
+	}
 
 	for _, image := range imgs {
 		repoDigest := ""
 		if len(image.RepoDigests) > 0 {
 			repoDigest = image.RepoDigests[0]
 		}
-		s := strings.Split(repoDigest, "@")
-		if len(s) != 2 {
-			dm.logger.Warnf("Invalid repo digest for image %s, skipping", image.ID)
-			continue
-		}
 
 		tag := ""
 		if len(image.RepoTags) > 0 {
 			tag = image.RepoTags[0]
 		}
 
+		// Images loaded from a bundle have no repo digest, fall back to the offline tag they were saved with
+		if repoDigest == "" {
+			repoDigest = trustedOfflineRepoDigest(image.RepoTags, image.ID, loaded)
+		}
+		s := strings.Split(repoDigest, "@")
+		if len(s) != 2 {
+			dm.logger.Warnf("Invalid repo digest for image %s, skipping", image.ID)
+			continue
+		}
+
 		images = append(images, DockerImageDetails{
 			Repository: s[0],
 			Tag:        tag,
@@ -246,48 +309,231 @@ func (dm *LocalDockerManager) GetContainersRunningImage(repoDigest string) ([]Do
 	return containersRunningImage, nil
 }
 
-func (dm *LocalDockerManager) PullImage(ctx context.Context, imageName string, repoDigest string) error {
-	dm.logger.Debugf("Pulling image %s %s", imageName, repoDigest)
-	imagePullOptions := docker_types.ImagePullOptions{}
+// SetRegistryAuth replaces the credentials used for pulls, pulls already in progress keep their credentials
+func (dm *LocalDockerManager) SetRegistryAuth(auth *RegistryAuth) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if auth != nil {
+		auth.logger = dm.logger
+	}
+	dm.auth = auth
+}
 
-	if dm.username != "" && dm.password != "" {
-		imagePullOptions = docker_types.ImagePullOptions{
-			RegistryAuth: base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"username":"%s","password":"%s"}`, dm.username, dm.password))),
-		}
+// PullImage pulls the image, reporting the download progress to progress if it isn't nil
+func (dm *LocalDockerManager) PullImage(ctx context.Context, imageName string, repoDigest string, progress *PullProgress) error {
+	dm.logger.Debugf("Pulling image %s %s", imageName, repoDigest)
+	dm.mu.RLock()
+	auth := dm.auth
+	dm.mu.RUnlock()
+	registryAuth, err := auth.EncodedAuthFor(imageName)
+	if err != nil {
+		return err
 	}
+	imagePullOptions := docker_types.ImagePullOptions{RegistryAuth: registryAuth}
 	rc, err := dm.dockerClient.ImagePull(ctx, fmt.Sprintf("%s@%s", imageName, repoDigest), imagePullOptions)
 	if err != nil {
 		return err
 	}
 	defer rc.Close()
+	progress.start()
 
-	// ...
 	decoder := json.NewDecoder(rc)
 	for {
-		var message map[string]interface{}
+		var message pullMessage
 		if err := decoder.Decode(&message); err != nil {
 			if err == io.EOF {
 				break
 			}
-			dm.logger.Warn(err)
+			return fmt.Errorf("unable to read pull progress of %s: %w", imageName, err)
 		}
 
-		// process message
-		dm.logger.Debugf("%v", message)
+		// Only the fields we know are safe are logged
+		dm.logger.Debugf("%s %s", message.Status, message.ID)
+		if err := progress.update(&message); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (dm *LocalDockerManager) CreateContainer(imageName string, repoDigest string, entry_point_args []string, options map[string]interface{}, host_options map[string]interface{}, logger logging.Logger, cancelCtx context.Context) (DockerContainer, error) {
+// DiskUsage returns what the daemon reports from /system/df along with the free space on its data root
+func (dm *LocalDockerManager) DiskUsage(ctx context.Context) (*DiskUsage, error) {
+	info, err := dm.dockerClient.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	df, err := dm.dockerClient.DiskUsage(ctx, docker_types.DiskUsageOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &DiskUsage{
+		DataRoot:    info.DockerRootDir,
+		FreeBytes:   freeBytes(info.DockerRootDir),
+		LayersBytes: df.LayersSize,
+	}
+	for _, image := range df.Images {
+		usage.Images = append(usage.Images, DiskUsageImage{
+			ID:          image.ID,
+			RepoDigests: image.RepoDigests,
+			RepoTags:    image.RepoTags,
+			Created:     time.Unix(image.Created, 0),
+			UniqueSize:  image.Size - max(image.SharedSize, 0),
+			Containers:  image.Containers,
+		})
+	}
+	for _, c := range df.Containers {
+		usage.ContainersBytes += c.SizeRw
+	}
+	for _, v := range df.Volumes {
+		if v.UsageData != nil && v.UsageData.Size > 0 {
+			usage.VolumesBytes += v.UsageData.Size
+		}
+	}
+	for _, cache := range df.BuildCache {
+		usage.BuildCacheBytes += cache.Size
+	}
+	return usage, nil
+}
+
+// HostPlatform returns the platform of the daemon, the one images are pulled for
+func (dm *LocalDockerManager) HostPlatform(ctx context.Context) (Platform, error) {
+	info, err := dm.dockerClient.Info(ctx)
+	if err != nil {
+		return Platform{}, err
+	}
+	return Platform{OS: info.OSType, Architecture: info.Architecture}.normalize(), nil
+}
+
+// ImagePlatform returns the platform of a local image, from its image config
+func (dm *LocalDockerManager) ImagePlatform(ctx context.Context, image PinnedImage) (Platform, error) {
+	inspect, _, err := dm.dockerClient.ImageInspectWithRaw(ctx, dm.imageReference(ctx, image))
+	if err != nil {
+		return Platform{}, err
+	}
+	return Platform{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant}, nil
+}
+
+// EnsureNetwork creates the network if it doesn't exist, or checks that the existing network matches the options
+func (dm *LocalDockerManager) EnsureNetwork(ctx context.Context, options *NetworkOptions) error {
+	existing, err := dm.dockerClient.NetworkInspect(ctx, options.Name, docker_types.NetworkInspectOptions{})
+	if err == nil {
+		subnets := make([]string, 0, len(existing.IPAM.Config))
+		for _, config := range existing.IPAM.Config {
+			subnets = append(subnets, config.Subnet)
+		}
+		return options.matches(existing.Driver, subnets)
+	}
+	if !client.IsErrNotFound(err) {
+		return err
+	}
+
+	create := docker_types.NetworkCreate{Driver: options.driver(), Labels: options.labels()}
+	if options.Subnet != "" {
+		create.IPAM = &network.IPAM{Config: []network.IPAMConfig{{Subnet: options.Subnet, Gateway: options.Gateway}}}
+	}
+	if options.Parent != "" {
+		create.Options = map[string]string{"parent": options.Parent}
+	}
+	if _, err := dm.dockerClient.NetworkCreate(ctx, options.Name, create); err != nil {
+		return fmt.Errorf("unable to create network %s: %w", options.Name, err)
+	}
+	dm.logger.Infof("Created %s network %s", options.driver(), options.Name)
+	return nil
+}
+
+// RemoveNetwork removes a network the module created, networks created any other way are left alone
+func (dm *LocalDockerManager) RemoveNetwork(ctx context.Context, name string) error {
+	existing, err := dm.dockerClient.NetworkInspect(ctx, name, docker_types.NetworkInspectOptions{})
+	if client.IsErrNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Labels[managedLabel] != "true" {
+		return nil
+	}
+	if err := dm.dockerClient.NetworkRemove(ctx, existing.ID); err != nil {
+		return err
+	}
+	dm.logger.Infof("Removed network %s", name)
+	return nil
+}
+
+// EnsureVolume creates the volume with the labels if it doesn't exist. Existing volumes are used as they are.
+func (dm *LocalDockerManager) EnsureVolume(ctx context.Context, options *VolumeOptions, labels map[string]string) error {
+	if err := enforcePolicy(func(policy *Policy) []error {
+		return policy.checkVolumes([]VolumeOptions{*options})
+	}); err != nil {
+		return err
+	}
+	existing, err := dm.dockerClient.VolumeInspect(ctx, options.Name)
+	if err == nil {
+		if existing.Driver != options.driver() {
+			return fmt.Errorf("volume %s uses the %s driver, not %s: %w", options.Name, existing.Driver, options.driver(), ErrVolumeMismatch)
+		}
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return err
+	}
+	if _, err := dm.dockerClient.VolumeCreate(ctx, volume.CreateOptions{
+		Name:       options.Name,
+		Driver:     options.driver(),
+		DriverOpts: options.DriverOpts,
+		Labels:     labels,
+	}); err != nil {
+		return fmt.Errorf("unable to create volume %s: %w", options.Name, err)
+	}
+	dm.logger.Infof("Created volume %s", options.Name)
+	return nil
+}
+
+// ListManagedVolumes returns the volumes the module created
+func (dm *LocalDockerManager) ListManagedVolumes(ctx context.Context) ([]ManagedVolume, error) {
+	resp, err := dm.dockerClient.VolumeList(ctx, volume.ListOptions{Filters: filters.NewArgs(filters.Arg("label", managedLabel+"=true"))})
+	if err != nil {
+		return nil, err
+	}
+	volumes := make([]ManagedVolume, 0, len(resp.Volumes))
+	for _, v := range resp.Volumes {
+		volumes = append(volumes, managedVolumeFromLabels(v.Name, v.Labels))
+	}
+	return volumes, nil
+}
+
+func (dm *LocalDockerManager) RemoveVolume(ctx context.Context, name string) error {
+	return dm.dockerClient.VolumeRemove(ctx, name, false)
+}
+
+func (dm *LocalDockerManager) CreateContainer(imageName string, repoDigest string, runOptions *RunOptions, labels map[string]string, logger logging.Logger, cancelCtx context.Context) (DockerContainer, error) {
+	if err := enforcePolicy(func(policy *Policy) []error {
+		return append(policy.checkImage(imageName), policy.checkHostOptions(runOptions.HostOptions)...)
+	}); err != nil {
+		return nil, err
+	}
+
+	exposedPorts, portBindings, err := parsePorts(runOptions.Ports)
+	if err != nil {
+		return nil, err
+	}
 	config := &container.Config{
-		Image: fmt.Sprintf("%s@%s", imageName, repoDigest),
-		Cmd:   entry_point_args,
+		Image:        dm.imageReference(cancelCtx, PinnedImage{ImageName: imageName, RepoDigest: repoDigest}),
+		Cmd:          runOptions.EntryPointArgs,
+		Env:          runOptions.Env,
+		ExposedPorts: exposedPorts,
+		Healthcheck:  runOptions.Healthcheck.healthConfig(),
+		Labels:       labels,
 	}
 
-	hostConfig := &container.HostConfig{}
+	hostConfig := &container.HostConfig{PortBindings: portBindings}
+	for _, v := range runOptions.Volumes {
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{Type: mount.TypeVolume, Source: v.Name, Target: v.Target, ReadOnly: v.ReadOnly})
+	}
 
-	for key, value := range host_options {
+	for key, value := range runOptions.HostOptions {
 		switch key {
 		case "NetworkMode":
 			if v, ok := value.(string); ok {
@@ -306,7 +552,17 @@ func (dm *LocalDockerManager) CreateContainer(imageName string, repoDigest strin
 		}
 	}
 
-	resp, err := dm.dockerClient.ContainerCreate(cancelCtx, config, hostConfig, nil, nil, "")
+	// Older daemons only accept one network when the container is created, it is connected to the others after
+	var networkingConfig *network.NetworkingConfig
+	primary := primaryNetwork(runOptions)
+	if primary != nil {
+		hostConfig.NetworkMode = container.NetworkMode(primary.Name)
+		networkingConfig = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+			primary.Name: primary.endpointSettings(),
+		}}
+	}
+
+	resp, err := dm.dockerClient.ContainerCreate(cancelCtx, config, hostConfig, networkingConfig, nil, "")
 	if err != nil {
 		return nil, err
 	}
@@ -315,31 +571,30 @@ func (dm *LocalDockerManager) CreateContainer(imageName string, repoDigest strin
 		logger.Warnf("Create container warning: %s", w)
 	}
 
-	c := NewDockerContainer(dm.dockerClient, resp.ID, imageName, repoDigest, logger, cancelCtx)
+	for i := range runOptions.Networks {
+		n := &runOptions.Networks[i]
+		if n == primary {
+			continue
+		}
+		if err := dm.dockerClient.NetworkConnect(cancelCtx, n.Name, resp.ID, n.endpointSettings()); err != nil {
+			dm.RemoveContainer(resp.ID)
+			return nil, fmt.Errorf("unable to connect to network %s: %w", n.Name, err)
+		}
+	}
+
+	c := NewDockerContainer(dm, resp.ID, imageName, repoDigest, logger, cancelCtx)
 	return c, nil
 }
 
-func (dm *LocalDockerManager) CreateComposeContainers(imageName string, repoDigest string, composeFile []string, logger logging.Logger, cancelCtx context.Context) ([]DockerContainer, error) {
+func (dm *LocalDockerManager) CreateComposeContainers(imageName string, repoDigest string, composeFile []string, labels map[string]string, logger logging.Logger, cancelCtx context.Context) ([]DockerContainer, error) {
 	ctx := context.Background()
-	sanitizedImageName := strings.Replace(imageName, "/", "-", -1)
-	composeFileName := fmt.Sprintf("%s/%s-%s.yml", os.TempDir(), "docker-compose", sanitizedImageName)
-	b := make([]byte, 0)
-	for _, line := range composeFile {
-		s := []byte(fmt.Sprintln(line))
-		b = append(b, []byte(s)...)
-	}
-
-	yaml, err := loader.ParseYAML(b)
+	project, err := loadComposeProject(imageName, composeFile)
 	if err != nil {
 		return nil, err
 	}
-
-	project, err := loader.Load(compose_types.ConfigDetails{
-		WorkingDir:  ".",
-		ConfigFiles: []compose_types.ConfigFile{{Config: yaml, Filename: composeFileName}},
-		Environment: map[string]string{},
-	})
-	if err != nil {
+	if err := enforcePolicy(func(policy *Policy) []error {
+		return policy.checkComposeProject(project)
+	}); err != nil {
 		return nil, err
 	}
 
@@ -359,22 +614,72 @@ func (dm *LocalDockerManager) CreateComposeContainers(imageName string, repoDige
 			exposedPorts[natPort] = struct{}{}
 		}
 		config := &container.Config{
-			Image:        service.Image,
+			Image:        dm.composeImageReference(ctx, service.Image),
 			Env:          env,
 			ExposedPorts: exposedPorts,
+			Healthcheck:  composeHealthConfig(service.HealthCheck),
+			StopSignal:   service.StopSignal,
+			Labels:       labels,
+		}
+		// The component's stop_signal and stop_timeout_seconds take precedence when the module stops the container
+		if service.StopGracePeriod != nil {
+			timeout := int(time.Duration(*service.StopGracePeriod).Seconds())
+			config.StopTimeout = &timeout
 		}
 
-		resp, err := dm.dockerClient.ContainerCreate(ctx, config, nil, nil, nil, service.Name)
+		hostConfig := &container.HostConfig{Mounts: composeServiceMounts(project, service)}
+		var networkingConfig *network.NetworkingConfig
+		networks, endpoints := composeServiceNetworks(project, service)
+		if len(networks) > 0 {
+			hostConfig.NetworkMode = container.NetworkMode(networks[0])
+			networkingConfig = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+				networks[0]: endpoints[networks[0]],
+			}}
+		}
+
+		resp, err := dm.dockerClient.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, service.Name)
 		if err != nil {
 			return nil, err
 		}
 
+		for i, name := range networks {
+			if i == 0 {
+				continue
+			}
+			if err := dm.dockerClient.NetworkConnect(ctx, name, resp.ID, endpoints[name]); err != nil {
+				dm.RemoveContainer(resp.ID)
+				return nil, fmt.Errorf("unable to connect %s to network %s: %w", service.Name, name, err)
+			}
+		}
+
 		dm.logger.Infof("Container %s has been created", resp.ID)
-		containers = append(containers, NewDockerContainer(dm.dockerClient, resp.ID, imageName, repoDigest, logger, cancelCtx))
+		containers = append(containers, NewDockerContainer(dm, resp.ID, imageName, repoDigest, logger, cancelCtx))
 	}
 	return containers, nil
 }
 
+// loadComposeProject parses the compose file from the config, one line per entry
+func loadComposeProject(imageName string, composeFile []string) (*compose_types.Project, error) {
+	sanitizedImageName := strings.Replace(imageName, "/", "-", -1)
+	composeFileName := fmt.Sprintf("%s/%s-%s.yml", os.TempDir(), "docker-compose", sanitizedImageName)
+	b := make([]byte, 0)
+	for _, line := range composeFile {
+		s := []byte(fmt.Sprintln(line))
+		b = append(b, []byte(s)...)
+	}
+
+	yaml, err := loader.ParseYAML(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return loader.Load(compose_types.ConfigDetails{
+		WorkingDir:  ".",
+		ConfigFiles: []compose_types.ConfigFile{{Config: yaml, Filename: composeFileName}},
+		Environment: map[string]string{},
+	})
+}
+
 func (dm *LocalDockerManager) ImageExists(repoDigest string) (bool, error) {
 	images, err := dm.ListImages()
 	if err != nil {
@@ -389,14 +694,329 @@ func (dm *LocalDockerManager) ImageExists(repoDigest string) (bool, error) {
 	return false, nil
 }
 
+// InspectContainer implements ContainerInspector with the Docker API, which Podman provides too
+func (dm *LocalDockerManager) InspectContainer(ctx context.Context, containerId string) (*ContainerInspect, error) {
+	inspect, err := dm.dockerClient.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return nil, err
+	}
+	return containerInspectFromDocker(inspect), nil
+}
+
+// containerInspectFromDocker converts Docker's inspect result, which has no state for containers being created
+func containerInspectFromDocker(inspect docker_types.ContainerJSON) *ContainerInspect {
+	ci := &ContainerInspect{Health: containerHealthFromState(nil)}
+	if inspect.ContainerJSONBase == nil {
+		return ci
+	}
+	ci.ID, ci.ImageID = inspect.ID, inspect.Image
+	if inspect.State != nil {
+		ci.Running = inspect.State.Running
+		ci.ExitCode = inspect.State.ExitCode
+		ci.Health = containerHealthFromState(inspect.State.Health)
+	}
+	return ci
+}
+
+// ContainerAddress returns the address the module can reach a port of the container at. That is the published host
+// port if there is one, the port on localhost for host networking, or else the container's IP on its first network.
+// The container can also be referenced by name.
+func (dm *LocalDockerManager) ContainerAddress(ctx context.Context, containerId string, port int) (string, error) {
+	inspect, err := dm.dockerClient.ContainerInspect(ctx, containerId)
+	if err != nil {
+		return "", err
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return "", fmt.Errorf("%w: %s", ErrContainerNotRunning, containerId)
+	}
+	if inspect.HostConfig != nil && inspect.HostConfig.NetworkMode.IsHost() {
+		return net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), nil
+	}
+	if inspect.NetworkSettings == nil {
+		return "", fmt.Errorf("container %s doesn't have an address", containerId)
+	}
+	for _, binding := range inspect.NetworkSettings.Ports[nat.Port(fmt.Sprintf("%d/tcp", port))] {
+		host := binding.HostIP
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		return net.JoinHostPort(host, binding.HostPort), nil
+	}
+	networks := make([]string, 0, len(inspect.NetworkSettings.Networks))
+	for name := range inspect.NetworkSettings.Networks {
+		networks = append(networks, name)
+	}
+	sort.Strings(networks)
+	for _, name := range networks {
+		if ip := inspect.NetworkSettings.Networks[name].IPAddress; ip != "" {
+			return net.JoinHostPort(ip, strconv.Itoa(port)), nil
+		}
+	}
+	return "", fmt.Errorf("container %s doesn't have an address", containerId)
+}
+
 func (dm *LocalDockerManager) StartContainer(containerId string) error {
 	return dm.dockerClient.ContainerStart(context.Background(), containerId, container.StartOptions{})
 }
 
-func (dm *LocalDockerManager) StopContainer(containerId string) error {
-	return dm.dockerClient.ContainerStop(context.Background(), containerId, container.StopOptions{})
+// StopContainer stops the container, options left empty use the container's stop signal and timeout
+func (dm *LocalDockerManager) StopContainer(containerId string, options container.StopOptions) error {
+	return dm.dockerClient.ContainerStop(context.Background(), containerId, options)
+}
+
+// ExecContainer runs a command in the running container and returns its exit code and combined output
+func (dm *LocalDockerManager) ExecContainer(ctx context.Context, containerId string, cmd []string) (int, string, error) {
+	exec, err := dm.dockerClient.ContainerExecCreate(ctx, containerId, docker_types.ExecConfig{Cmd: cmd, AttachStdout: true, AttachStderr: true})
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := dm.dockerClient.ContainerExecAttach(ctx, exec.ID, docker_types.ExecStartCheck{})
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Close()
+
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, resp.Reader); err != nil {
+		return 0, "", err
+	}
+	inspect, err := dm.dockerClient.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return 0, "", err
+	}
+	return inspect.ExitCode, output.String(), nil
+}
+
+func (dm *LocalDockerManager) PauseContainer(containerId string) error {
+	return dm.dockerClient.ContainerPause(context.Background(), containerId)
+}
+
+func (dm *LocalDockerManager) UnpauseContainer(containerId string) error {
+	return dm.dockerClient.ContainerUnpause(context.Background(), containerId)
+}
+
+// ListLabeledContainers returns the ids of the containers, running or not, that carry all of the labels
+func (dm *LocalDockerManager) ListLabeledContainers(ctx context.Context, labels map[string]string) ([]string, error) {
+	args := filters.NewArgs()
+	for k, v := range labels {
+		args.Add("label", k+"="+v)
+	}
+	containers, err := dm.dockerClient.ContainerList(ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(containers))
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
 }
 
 func (dm *LocalDockerManager) RemoveContainer(containerId string) error {
 	return dm.dockerClient.ContainerRemove(context.Background(), containerId, container.RemoveOptions{Force: true})
 }
+
+func (dm *LocalDockerManager) SaveImages(ctx context.Context, images []PinnedImage, w io.Writer) error {
+	// Images pulled by digest have no tag, and `docker load` drops repo digests, so we tag each image with its
+	// offline tag before saving it. That tag survives the round trip and lets ImageExists find the image again.
+	tags := make([]string, 0, len(images))
+	for _, image := range images {
+		tag, err := offlineImageTag(image)
+		if err != nil {
+			return err
+		}
+		if err := dm.dockerClient.ImageTag(ctx, dm.imageReference(ctx, image), tag); err != nil {
+			return err
+		}
+		tags = append(tags, tag)
+	}
+
+	rc, err := dm.dockerClient.ImageSave(ctx, tags)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+func (dm *LocalDockerManager) LoadImages(ctx context.Context, r io.Reader) error {
+	resp, err := dm.dockerClient.ImageLoad(ctx, r, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Stream      string `json:"stream"`
+			ErrorDetail *struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if message.ErrorDetail != nil {
+			return errors.New(message.ErrorDetail.Message)
+		}
+		if message.Stream != "" {
+			dm.logger.Info(strings.TrimSpace(message.Stream))
+		}
+	}
+	return nil
+}
+
+func (dm *LocalDockerManager) ExportVolume(ctx context.Context, volumeName string, helperImage PinnedImage, w io.Writer) error {
+	helperId, err := dm.createVolumeHelper(ctx, volumeName, helperImage)
+	if err != nil {
+		return err
+	}
+	defer dm.RemoveContainer(helperId)
+
+	rc, _, err := dm.dockerClient.CopyFromContainer(ctx, helperId, volumeHelperMountPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+func (dm *LocalDockerManager) ImportVolume(ctx context.Context, volumeName string, helperImage PinnedImage, r io.Reader) error {
+	if _, err := dm.dockerClient.VolumeCreate(ctx, volume.CreateOptions{Name: volumeName}); err != nil {
+		return err
+	}
+	// The volume ends up with exactly the contents of the archive, not the archive merged into what was there
+	if err := dm.emptyVolume(ctx, volumeName, helperImage); err != nil {
+		return fmt.Errorf("unable to empty volume %s before restoring it: %w", volumeName, err)
+	}
+
+	helperId, err := dm.createVolumeHelper(ctx, volumeName, helperImage)
+	if err != nil {
+		return err
+	}
+	defer dm.RemoveContainer(helperId)
+
+	// The archive was produced by ExportVolume, so its entries are already rooted at the helper mount path
+	return dm.dockerClient.CopyToContainer(ctx, helperId, path.Dir(volumeHelperMountPath), r, docker_types.CopyToContainerOptions{})
+}
+
+// emptyVolume removes everything in the volume by running rm in a helper container, the helper image needs sh and rm
+func (dm *LocalDockerManager) emptyVolume(ctx context.Context, volumeName string, helperImage PinnedImage) error {
+	config := &container.Config{
+		Image:      dm.imageReference(ctx, helperImage),
+		User:       "0",
+		Entrypoint: []string{"sh", "-c"},
+		// The globs match every entry except . and .., unmatched globs are passed to rm -f as is and ignored
+		Cmd: []string{fmt.Sprintf("rm -rf %[1]s/..?* %[1]s/.[!.]* %[1]s/*", volumeHelperMountPath)},
+	}
+	hostConfig := &container.HostConfig{
+		Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: volumeName, Target: volumeHelperMountPath}},
+	}
+	resp, err := dm.dockerClient.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer dm.RemoveContainer(resp.ID)
+
+	waitC, errC := dm.dockerClient.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+	if err := dm.dockerClient.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return err
+	}
+	select {
+	case result := <-waitC:
+		if result.StatusCode != 0 {
+			return fmt.Errorf("rm exited with status %d", result.StatusCode)
+		}
+		return nil
+	case err := <-errC:
+		return err
+	}
+}
+
+// createVolumeHelper creates, but never starts, a container with the volume mounted so we can copy data in and out of it.
+func (dm *LocalDockerManager) createVolumeHelper(ctx context.Context, volumeName string, helperImage PinnedImage) (string, error) {
+	config := &container.Config{
+		Image: dm.imageReference(ctx, helperImage),
+		Cmd:   []string{"true"},
+	}
+	hostConfig := &container.HostConfig{
+		Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: volumeName, Target: volumeHelperMountPath}},
+	}
+	resp, err := dm.dockerClient.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// imageReference returns the reference to use for a pinned image. Images that were pulled are referenced by digest,
+// images that were loaded from a bundle can only be referenced by their offline tag.
+func (dm *LocalDockerManager) imageReference(ctx context.Context, image PinnedImage) string {
+	ref := image.String()
+	if _, _, err := dm.dockerClient.ImageInspectWithRaw(ctx, ref); err == nil {
+		return ref
+	}
+	tag, err := offlineImageTag(image)
+	if err != nil {
+		return ref
+	}
+	loaded, err := loadedImages()
+	if err != nil {
+		return ref
+	}
+	if inspect, _, err := dm.dockerClient.ImageInspectWithRaw(ctx, tag); err == nil && inspect.ID == loaded[image.RepoDigest] {
+		return tag
+	}
+	return ref
+}
+
+// OfflineImageID returns the id of the image carrying the offline tag of the pinned image
+func (dm *LocalDockerManager) OfflineImageID(ctx context.Context, image PinnedImage) (string, error) {
+	tag, err := offlineImageTag(image)
+	if err != nil {
+		return "", err
+	}
+	inspect, _, err := dm.dockerClient.ImageInspectWithRaw(ctx, tag)
+	if err != nil {
+		return "", err
+	}
+	return inspect.ID, nil
+}
+
+// composeImageReference is imageReference for the `image` field of a compose service
+func (dm *LocalDockerManager) composeImageReference(ctx context.Context, imageRef string) string {
+	s := strings.Split(imageRef, "@")
+	if len(s) != 2 {
+		return imageRef
+	}
+	return dm.imageReference(ctx, PinnedImage{ImageName: s[0], RepoDigest: s[1]})
+}
+
+// offlineImageTag returns the tag an image is saved with when it is written to a bundle, e.g. ubuntu:sha256-04714a...
+func offlineImageTag(image PinnedImage) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image.ImageName)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s", reference.FamiliarName(reference.TrimNamed(named)), strings.Replace(image.RepoDigest, ":", "-", 1)), nil
+}
+
+// repoDigestFromOfflineTags is the inverse of offlineImageTag, it returns "repository@digest" or an empty string.
+func repoDigestFromOfflineTags(tags []string) string {
+	for _, tag := range tags {
+		i := strings.LastIndex(tag, ":")
+		if i < 0 || !strings.HasPrefix(tag[i+1:], "sha256-") {
+			continue
+		}
+		return fmt.Sprintf("%s@%s", tag[:i], strings.Replace(tag[i+1:], "-", ":", 1))
+	}
+	return ""
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	docker_types "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/stretchr/testify/assert"
@@ -80,10 +81,10 @@ func TestListImages(t *testing.T) {
 func TestGetContainerImageDigest(t *testing.T) {
 	logger, dm := docker_manager_test_setup(t)
 	ctx, _ := context.WithCancel(context.Background())
-	err := dm.PullImage(ctx, imageName, repoDigest)
+	err := dm.PullImage(ctx, imageName, repoDigest, nil)
 	assert.NoError(t, err)
 
-	container, err := dm.CreateContainer(imageName, repoDigest, []string{"sleep", "1000"}, options, hostOptions, logger, ctx)
+	container, err := dm.CreateContainer(imageName, repoDigest, &RunOptions{EntryPointArgs: []string{"sleep", "1000"}, Options: options, HostOptions: hostOptions}, nil, logger, ctx)
 	assert.NoError(t, err)
 	digest, err := dm.GetContainerImageDigest(container.GetContainerId())
 	if err != nil {
@@ -98,10 +99,10 @@ func TestGetContainersRunningImage(t *testing.T) {
 	ctx, _ := context.WithCancel(context.Background())
 
 	logger, dm := docker_manager_test_setup(t)
-	err := dm.PullImage(ctx, imageName, repoDigest)
+	err := dm.PullImage(ctx, imageName, repoDigest, nil)
 	assert.NoError(t, err)
 
-	container, err := dm.CreateContainer(imageName, repoDigest, []string{"sleep", "1000"}, options, hostOptions, logger, ctx)
+	container, err := dm.CreateContainer(imageName, repoDigest, &RunOptions{EntryPointArgs: []string{"sleep", "1000"}, Options: options, HostOptions: hostOptions}, nil, logger, ctx)
 	assert.NoError(t, err)
 
 	err = dm.StartContainer(container.GetContainerId())
@@ -127,7 +128,7 @@ func TestImageExists(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, exists)
 
-	err = dm.PullImage(ctx, imageName, repoDigest)
+	err = dm.PullImage(ctx, imageName, repoDigest, nil)
 	assert.NoError(t, err)
 	exists, err = dm.ImageExists(repoDigest)
 	assert.NoError(t, err)
@@ -143,7 +144,7 @@ func TestImagePull(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, exists)
 
-	err = dm.PullImage(ctx, imageName, repoDigest)
+	err = dm.PullImage(ctx, imageName, repoDigest, nil)
 	assert.NoError(t, err)
 	exists, err = dm.ImageExists(repoDigest)
 	assert.NoError(t, err)
@@ -159,7 +160,7 @@ func TestImageRemove(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, exists)
 
-	err = dm.PullImage(ctx, imageName, repoDigest)
+	err = dm.PullImage(ctx, imageName, repoDigest, nil)
 	assert.NoError(t, err)
 	exists, err = dm.ImageExists(repoDigest)
 	assert.NoError(t, err)
@@ -172,3 +173,25 @@ func TestImageRemove(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, exists)
 }
+
+func TestConvertContainerInspect(t *testing.T) {
+	inspect := docker_types.ContainerJSON{ContainerJSONBase: &docker_types.ContainerJSONBase{
+		ID:    "c1",
+		Image: "sha256:def",
+		State: &docker_types.ContainerState{
+			Running:  true,
+			ExitCode: 3,
+			Health:   &docker_types.Health{Status: "unhealthy", FailingStreak: 2, Log: []*docker_types.HealthcheckResult{{Output: "down"}}},
+		},
+	}}
+	assert.Equal(t, &ContainerInspect{
+		ID:       "c1",
+		ImageID:  "sha256:def",
+		Running:  true,
+		ExitCode: 3,
+		Health:   &ContainerHealth{Status: "unhealthy", FailingStreak: 2, LastOutput: "down"},
+	}, containerInspectFromDocker(inspect))
+
+	// A container being created has no state yet
+	assert.Equal(t, &ContainerInspect{Health: &ContainerHealth{}}, containerInspectFromDocker(docker_types.ContainerJSON{}))
+}
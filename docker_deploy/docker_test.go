@@ -2,6 +2,7 @@ package docker_deploy
 
 import (
 	"context"
+	"errors"
 	"os"
 	"strings"
 	"testing"
@@ -173,3 +174,55 @@ func TestRunOnce(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(containers))
 }
+
+func newTestDockerConfig(t *testing.T, manager DockerManager) *DockerConfig {
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	t.Cleanup(cancelFunc)
+	return &DockerConfig{
+		Named:      resource.NewName(sensor.API, "container0").AsNamed(),
+		logger:     logging.NewTestLogger(t),
+		cancelCtx:  cancelCtx,
+		cancelFunc: cancelFunc,
+		containers: []DockerContainer{},
+		manager:    manager,
+
+		pullCoordinator: newPullCoordinator(defaultMaxConcurrentPulls),
+	}
+}
+
+func TestPullRetriesWithBackoff(t *testing.T) {
+	manager := newFakeDockerManager()
+	manager.pullErrors = []error{errors.New("connection reset"), errors.New("connection reset")}
+	dc := newTestDockerConfig(t, manager)
+
+	conf := &Config{PullRetry: &PullRetryOptions{MaxAttempts: 3, InitialBackoffSeconds: 0.001}}
+	err := dc.ensureImage(context.Background(), PinnedImage{ImageName: "ubuntu", RepoDigest: "sha256:abc"}, conf)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, manager.pulls)
+	assert.Equal(t, PullStateComplete, dc.pullStatus()["state"])
+	assert.Equal(t, 3, dc.pullStatus()["attempt"])
+}
+
+func TestPullFailedState(t *testing.T) {
+	manager := newFakeDockerManager()
+	manager.pullErrors = []error{errors.New("unauthorized"), errors.New("unauthorized")}
+	dc := newTestDockerConfig(t, manager)
+
+	conf := &Config{
+		ImageName:  "ubuntu",
+		RepoDigest: "sha256:abc",
+		RunOptions: &RunOptions{},
+		PullRetry:  &PullRetryOptions{MaxAttempts: 2, InitialBackoffSeconds: 0.001},
+	}
+	dc.startDownload(context.Background(), conf)
+	assert.Equal(t, 2, manager.pulls)
+
+	readings, err := dc.Readings(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "pull_failed", readings["state"])
+	assert.Equal(t, "unauthorized", readings["pull_failed_reason"])
+
+	ready, err := dc.Ready(context.Background(), nil)
+	assert.False(t, ready)
+	assert.ErrorContains(t, err, "unauthorized")
+}
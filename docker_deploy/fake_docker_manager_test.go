@@ -0,0 +1,381 @@
+package docker_deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"go.viam.com/rdk/logging"
+)
+
+// fakeDockerManager implements the parts of DockerManager the daemon-free tests need, calling anything else panics
+type fakeDockerManager struct {
+	DockerManager
+
+	mu         sync.Mutex
+	images     map[string]bool
+	pullErrors []error
+	pulls      int
+
+	diskUsage         *DiskUsage
+	removedImages     []string
+	stoppedContainers []string
+	imagePlatforms    map[string]Platform
+	networks          map[string]*NetworkOptions
+	removedNetworks   []string
+	volumes           map[string]map[string]string
+	removedVolumes    []string
+	exportedVolumes   []string
+	volumeHelpers     []PinnedImage
+	importedVolumes   map[string]string
+	containerEvents   []string
+	containerLabels   map[string]map[string]string
+	offlineImages     map[string]string
+	addresses         map[string]string
+	stopOptions       []container.StopOptions
+	execExitCode      int
+	created           int
+}
+
+func newFakeDockerManager() *fakeDockerManager {
+	return &fakeDockerManager{images: map[string]bool{}}
+}
+
+func (fm *fakeDockerManager) ImageExists(repoDigest string) (bool, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.images[repoDigest], nil
+}
+
+// LoadImages reads a JSON object of the digests in the archive and the image ids they are loaded as
+func (fm *fakeDockerManager) LoadImages(ctx context.Context, r io.Reader) error {
+	var loaded map[string]string
+	if err := json.NewDecoder(r).Decode(&loaded); err != nil {
+		return err
+	}
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if fm.offlineImages == nil {
+		fm.offlineImages = map[string]string{}
+	}
+	for digest, imageId := range loaded {
+		fm.offlineImages[digest] = imageId
+		fm.images[digest] = true
+	}
+	return nil
+}
+
+func (fm *fakeDockerManager) OfflineImageID(ctx context.Context, image PinnedImage) (string, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	imageId, ok := fm.offlineImages[image.RepoDigest]
+	if !ok {
+		return "", fmt.Errorf("no such image: %s", image)
+	}
+	return imageId, nil
+}
+
+// PullImage fails with the queued pullErrors before succeeding
+func (fm *fakeDockerManager) PullImage(ctx context.Context, imageName string, repoDigest string, progress *PullProgress) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.pulls++
+	if len(fm.pullErrors) > 0 {
+		err := fm.pullErrors[0]
+		fm.pullErrors = fm.pullErrors[1:]
+		return err
+	}
+	fm.images[repoDigest] = true
+	return nil
+}
+
+func (fm *fakeDockerManager) SetRegistryAuth(auth *RegistryAuth) {}
+
+func (fm *fakeDockerManager) HostPlatform(ctx context.Context) (Platform, error) {
+	return Platform{OS: "linux", Architecture: "arm64"}, nil
+}
+
+// ImagePlatform returns the platform from imagePlatforms, images default to the host platform
+func (fm *fakeDockerManager) ImagePlatform(ctx context.Context, image PinnedImage) (Platform, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if platform, ok := fm.imagePlatforms[image.RepoDigest]; ok {
+		return platform, nil
+	}
+	return fm.HostPlatform(ctx)
+}
+
+// DiskUsage returns diskUsage, or an unknown amount of free space if it isn't set
+func (fm *fakeDockerManager) DiskUsage(ctx context.Context) (*DiskUsage, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if fm.diskUsage == nil {
+		return &DiskUsage{FreeBytes: -1}, nil
+	}
+	usage := *fm.diskUsage
+	usage.Images = append([]DiskUsageImage{}, fm.diskUsage.Images...)
+	return &usage, nil
+}
+
+// RemoveImageByImageId removes the image from diskUsage, freeing its space
+func (fm *fakeDockerManager) RemoveImageByImageId(imageId string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.removedImages = append(fm.removedImages, imageId)
+	if fm.diskUsage == nil {
+		return nil
+	}
+	for i, image := range fm.diskUsage.Images {
+		if image.ID == imageId {
+			fm.diskUsage.FreeBytes += image.UniqueSize
+			fm.diskUsage.Images = append(fm.diskUsage.Images[:i], fm.diskUsage.Images[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (fm *fakeDockerManager) StopContainer(containerId string, options container.StopOptions) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.stoppedContainers = append(fm.stoppedContainers, containerId)
+	fm.stopOptions = append(fm.stopOptions, options)
+	fm.containerEvents = append(fm.containerEvents, "stop "+containerId)
+	return nil
+}
+
+// ExecContainer records the command and exits with execExitCode
+func (fm *fakeDockerManager) ExecContainer(ctx context.Context, containerId string, cmd []string) (int, string, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.containerEvents = append(fm.containerEvents, fmt.Sprintf("exec %s %s", containerId, strings.Join(cmd, " ")))
+	return fm.execExitCode, "", nil
+}
+
+func (fm *fakeDockerManager) StartContainer(containerId string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.containerEvents = append(fm.containerEvents, "start "+containerId)
+	return nil
+}
+
+func (fm *fakeDockerManager) PauseContainer(containerId string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.containerEvents = append(fm.containerEvents, "pause "+containerId)
+	return nil
+}
+
+func (fm *fakeDockerManager) UnpauseContainer(containerId string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.containerEvents = append(fm.containerEvents, "unpause "+containerId)
+	return nil
+}
+
+func (fm *fakeDockerManager) EnsureNetwork(ctx context.Context, options *NetworkOptions) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if fm.networks == nil {
+		fm.networks = map[string]*NetworkOptions{}
+	}
+	if existing, ok := fm.networks[options.Name]; ok {
+		return options.matches(existing.driver(), []string{existing.Subnet})
+	}
+	fm.networks[options.Name] = options
+	return nil
+}
+
+func (fm *fakeDockerManager) RemoveNetwork(ctx context.Context, name string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	delete(fm.networks, name)
+	fm.removedNetworks = append(fm.removedNetworks, name)
+	return nil
+}
+
+func (fm *fakeDockerManager) EnsureVolume(ctx context.Context, options *VolumeOptions, labels map[string]string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if fm.volumes == nil {
+		fm.volumes = map[string]map[string]string{}
+	}
+	if _, ok := fm.volumes[options.Name]; !ok {
+		fm.volumes[options.Name] = labels
+	}
+	return nil
+}
+
+func (fm *fakeDockerManager) ListManagedVolumes(ctx context.Context) ([]ManagedVolume, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	var volumes []ManagedVolume
+	for name, labels := range fm.volumes {
+		if labels[managedLabel] == "true" {
+			volumes = append(volumes, managedVolumeFromLabels(name, labels))
+		}
+	}
+	return volumes, nil
+}
+
+func (fm *fakeDockerManager) RemoveVolume(ctx context.Context, name string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	delete(fm.volumes, name)
+	fm.removedVolumes = append(fm.removedVolumes, name)
+	return nil
+}
+
+// ExportVolume writes the name of the volume as its contents
+func (fm *fakeDockerManager) ExportVolume(ctx context.Context, volumeName string, helperImage PinnedImage, w io.Writer) error {
+	fm.mu.Lock()
+	fm.exportedVolumes = append(fm.exportedVolumes, volumeName)
+	fm.volumeHelpers = append(fm.volumeHelpers, helperImage)
+	fm.containerEvents = append(fm.containerEvents, "export "+volumeName)
+	fm.mu.Unlock()
+	_, err := io.WriteString(w, volumeName)
+	return err
+}
+
+// ImportVolume records what was written to the volume
+func (fm *fakeDockerManager) ImportVolume(ctx context.Context, volumeName string, helperImage PinnedImage, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if fm.importedVolumes == nil {
+		fm.importedVolumes = map[string]string{}
+	}
+	fm.importedVolumes[volumeName] = string(b)
+	fm.containerEvents = append(fm.containerEvents, "import "+volumeName)
+	return nil
+}
+
+func (fm *fakeDockerManager) RemoveContainer(containerId string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.containerEvents = append(fm.containerEvents, "remove "+containerId)
+	delete(fm.containerLabels, containerId)
+	return nil
+}
+
+// ListLabeledContainers returns the containers created with all of the labels, sorted by id
+func (fm *fakeDockerManager) ListLabeledContainers(ctx context.Context, labels map[string]string) ([]string, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	var ids []string
+	for id, containerLabels := range fm.containerLabels {
+		matches := true
+		for k, v := range labels {
+			if containerLabels[k] != v {
+				matches = false
+			}
+		}
+		if matches {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// addContainer records the labels of a new container, callers hold fm.mu
+func (fm *fakeDockerManager) addContainer(id string, labels map[string]string) {
+	if fm.containerLabels == nil {
+		fm.containerLabels = map[string]map[string]string{}
+	}
+	fm.containerLabels[id] = labels
+}
+
+// CreateContainer creates c1, c2, ... in order
+func (fm *fakeDockerManager) CreateContainer(imageName string, repoDigest string, runOptions *RunOptions, labels map[string]string, logger logging.Logger, cancelCtx context.Context) (DockerContainer, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.created++
+	id := fmt.Sprintf("c%d", fm.created)
+	fm.containerEvents = append(fm.containerEvents, "create "+id)
+	fm.addContainer(id, labels)
+	return &fakeDockerContainer{id: id}, nil
+}
+
+// CreateComposeContainers creates a container named after each service, like the daemon does, which fails if a
+// container with that name already exists
+func (fm *fakeDockerManager) CreateComposeContainers(imageName string, repoDigest string, composeFile []string, labels map[string]string, logger logging.Logger, cancelCtx context.Context) ([]DockerContainer, error) {
+	project, err := loadComposeProject(imageName, composeFile)
+	if err != nil {
+		return nil, err
+	}
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	var containers []DockerContainer
+	for _, name := range project.ServiceNames() {
+		if _, ok := fm.containerLabels[name]; ok {
+			return nil, fmt.Errorf("the container name %q is already in use", name)
+		}
+		fm.containerEvents = append(fm.containerEvents, "create "+name)
+		fm.addContainer(name, labels)
+		containers = append(containers, &fakeDockerContainer{id: name})
+	}
+	return containers, nil
+}
+
+// fakeDockerContainer is a container that is always running, stopped containers report not running
+type fakeDockerContainer struct {
+	DockerContainer
+	id       string
+	stopped  bool
+	health   ContainerHealth
+	hasRun   bool
+	exitCode int
+}
+
+func (fc *fakeDockerContainer) GetHasRun() (bool, error) {
+	return fc.hasRun, nil
+}
+
+func (fc *fakeDockerContainer) SetHasRun() error {
+	fc.hasRun = true
+	return nil
+}
+
+func (fc *fakeDockerContainer) GetExitCode() (int, error) {
+	return fc.exitCode, nil
+}
+
+func (fc *fakeDockerContainer) GetContainerId() string {
+	return fc.id
+}
+
+func (fc *fakeDockerContainer) GetImageId() (string, error) {
+	return "sha256:image-" + fc.id, nil
+}
+
+func (fc *fakeDockerContainer) GetRepoDigest() string {
+	return ""
+}
+
+func (fc *fakeDockerContainer) IsRunning() (bool, error) {
+	return !fc.stopped, nil
+}
+
+func (fc *fakeDockerContainer) GetHealth() (*ContainerHealth, error) {
+	health := fc.health
+	return &health, nil
+}
+
+// ContainerAddress returns the address set in addresses, containers without one aren't running
+func (fm *fakeDockerManager) ContainerAddress(ctx context.Context, containerId string, port int) (string, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if address, ok := fm.addresses[containerId]; ok {
+		return address, nil
+	}
+	return "", ErrContainerNotRunning
+}
@@ -0,0 +1,126 @@
+package docker_deploy
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	compose_types "github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+var ErrHealthcheckTestRequired = errors.New("healthcheck.test is required")
+var ErrHealthcheckNegative = errors.New("healthcheck durations and retries must not be negative")
+
+// HealthcheckOptions is the docker healthcheck of the container, unset durations use the daemon's defaults
+type HealthcheckOptions struct {
+	Test               []string `json:"test"`
+	IntervalSeconds    float64  `json:"interval_seconds"`
+	TimeoutSeconds     float64  `json:"timeout_seconds"`
+	Retries            int      `json:"retries"`
+	StartPeriodSeconds float64  `json:"start_period_seconds"`
+}
+
+func (ho *HealthcheckOptions) validate() []error {
+	if ho == nil {
+		return nil
+	}
+	var validationErrors []error
+	if len(ho.Test) == 0 || (len(ho.Test) == 1 && isHealthcheckKeyword(ho.Test[0]) && ho.Test[0] != "NONE") {
+		validationErrors = append(validationErrors, ErrHealthcheckTestRequired)
+	}
+	if ho.IntervalSeconds < 0 || ho.TimeoutSeconds < 0 || ho.Retries < 0 || ho.StartPeriodSeconds < 0 {
+		validationErrors = append(validationErrors, ErrHealthcheckNegative)
+	}
+	return validationErrors
+}
+
+func isHealthcheckKeyword(s string) bool {
+	return s == "CMD" || s == "CMD-SHELL" || s == "NONE"
+}
+
+// healthConfig returns the healthcheck the container is created with. A test that doesn't start with CMD, CMD-SHELL
+// or NONE is run as CMD.
+func (ho *HealthcheckOptions) healthConfig() *container.HealthConfig {
+	if ho == nil {
+		return nil
+	}
+	test := ho.Test
+	if len(test) > 0 && !isHealthcheckKeyword(test[0]) {
+		test = append([]string{"CMD"}, test...)
+	}
+	return &container.HealthConfig{
+		Test:        test,
+		Interval:    seconds(ho.IntervalSeconds),
+		Timeout:     seconds(ho.TimeoutSeconds),
+		Retries:     ho.Retries,
+		StartPeriod: seconds(ho.StartPeriodSeconds),
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// composeHealthConfig returns the healthcheck of a compose service, or nil to use the image's
+func composeHealthConfig(healthcheck *compose_types.HealthCheckConfig) *container.HealthConfig {
+	if healthcheck == nil {
+		return nil
+	}
+	if healthcheck.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}
+	}
+	config := &container.HealthConfig{
+		Test:          healthcheck.Test,
+		Interval:      composeDuration(healthcheck.Interval),
+		Timeout:       composeDuration(healthcheck.Timeout),
+		StartPeriod:   composeDuration(healthcheck.StartPeriod),
+		StartInterval: composeDuration(healthcheck.StartInterval),
+	}
+	if healthcheck.Retries != nil {
+		config.Retries = int(*healthcheck.Retries)
+	}
+	return config
+}
+
+func composeDuration(d *compose_types.Duration) time.Duration {
+	if d == nil {
+		return 0
+	}
+	return time.Duration(*d)
+}
+
+// ContainerHealth is the state of the container's healthcheck, Status is empty if the container doesn't have one
+type ContainerHealth struct {
+	Status        string
+	FailingStreak int
+	LastOutput    string
+}
+
+// healthy reports whether the container can be considered healthy, containers without a healthcheck always are
+func (ch *ContainerHealth) healthy() bool {
+	return ch.Status == "" || ch.Status == types.NoHealthcheck || ch.Status == types.Healthy
+}
+
+func (ch *ContainerHealth) readings() map[string]interface{} {
+	readings := map[string]interface{}{
+		"status":         ch.Status,
+		"failing_streak": ch.FailingStreak,
+	}
+	if ch.LastOutput != "" {
+		readings["last_output"] = strings.TrimSpace(ch.LastOutput)
+	}
+	return readings
+}
+
+func containerHealthFromState(health *types.Health) *ContainerHealth {
+	if health == nil {
+		return &ContainerHealth{}
+	}
+	ch := &ContainerHealth{Status: health.Status, FailingStreak: health.FailingStreak}
+	if len(health.Log) > 0 {
+		ch.LastOutput = health.Log[len(health.Log)-1].Output
+	}
+	return ch
+}
@@ -0,0 +1,121 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateHealthcheck(t *testing.T) {
+	conf := validRunConfig()
+	conf.RunOptions.Healthcheck = &HealthcheckOptions{Test: []string{"CMD-SHELL", "pg_isready"}, IntervalSeconds: 5, Retries: 3}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	conf.RunOptions.Healthcheck = &HealthcheckOptions{Test: []string{"CMD"}, TimeoutSeconds: -1}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrHealthcheckTestRequired)
+	assert.ErrorIs(t, err, ErrHealthcheckNegative)
+}
+
+func TestHealthConfig(t *testing.T) {
+	var ho *HealthcheckOptions
+	assert.Nil(t, ho.healthConfig())
+
+	ho = &HealthcheckOptions{Test: []string{"/bin/check", "--quick"}, IntervalSeconds: 2.5, TimeoutSeconds: 1, Retries: 3, StartPeriodSeconds: 30}
+	config := ho.healthConfig()
+	assert.Equal(t, []string{"CMD", "/bin/check", "--quick"}, config.Test)
+	assert.Equal(t, 2500*time.Millisecond, config.Interval)
+	assert.Equal(t, time.Second, config.Timeout)
+	assert.Equal(t, 3, config.Retries)
+	assert.Equal(t, 30*time.Second, config.StartPeriod)
+
+	project, err := loadComposeProject("ubuntu", []string{
+		"services:",
+		"  app:",
+		"    image: ubuntu@sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		"    healthcheck:",
+		"      test: ['CMD', 'true']",
+		"      interval: 10s",
+		"      retries: 2",
+	})
+	assert.NoError(t, err)
+	config = composeHealthConfig(project.Services[0].HealthCheck)
+	assert.Equal(t, []string{"CMD", "true"}, config.Test)
+	assert.Equal(t, 10*time.Second, config.Interval)
+	assert.Equal(t, 2, config.Retries)
+}
+
+func TestReadyRequiresHealthyContainers(t *testing.T) {
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	ready, err := dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	api := &fakeDockerContainer{id: "api", health: ContainerHealth{Status: "healthy"}}
+	worker := &fakeDockerContainer{id: "worker"}
+	dc.containers = []DockerContainer{api, worker}
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	// Running but unhealthy
+	api.health = ContainerHealth{Status: "unhealthy", FailingStreak: 3, LastOutput: "connection refused\n"}
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	dc.containers = []DockerContainer{api}
+	readings, err := dc.Readings(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"status": "unhealthy", "failing_streak": 3, "last_output": "connection refused"}, readings["health"])
+
+	api.health = ContainerHealth{Status: "healthy"}
+	worker.stopped = true
+	dc.containers = []DockerContainer{api, worker}
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+}
+
+func TestReadyDownloadOnlyAndRunOnce(t *testing.T) {
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+
+	// Download only components are ready once their images are downloaded
+	dc.downloadOnly = true
+	ready, err := dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+	dc.downloaded = true
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	// run_once containers are ready while they run and once they have exited successfully
+	dc.downloadOnly = false
+	dc.appliedConf.RunOnce = true
+	job := &fakeDockerContainer{id: "job"}
+	dc.containers = []DockerContainer{job}
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	job.stopped, job.hasRun = true, true
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	job.exitCode = 1
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	// Without run_once a stopped container is never ready
+	job.exitCode = 0
+	dc.appliedConf.RunOnce = false
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+}
@@ -0,0 +1,392 @@
+package docker_deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.viam.com/rdk/resource"
+)
+
+var ErrInvalidTimeRange = errors.New("maintenance_window time ranges must look like 02:00-04:30")
+var ErrInvalidTimezone = errors.New("maintenance_window timezone is not a valid IANA timezone")
+var ErrInvalidCron = errors.New("maintenance_window cron entries must have 5 fields: minute hour day-of-month month day-of-week")
+var ErrCronDurationRequired = errors.New("maintenance_window duration_minutes is required with cron")
+var ErrWindowDependencyIncomplete = errors.New("maintenance_window dependency requires resource and reading")
+var ErrWindowDependencyNotFound = errors.New("maintenance_window dependency not found")
+var ErrUnknownWindowAction = errors.New("'action' must be status, open, hold or release")
+
+// How long the window stays open after {"command": "maintenance_window", "action": "open"} without duration_minutes
+const defaultWindowOpenDuration = time.Hour
+
+// How long the readings of a dependency are waited for when deciding if the window is open
+const windowDependencyTimeout = 5 * time.Second
+
+// MaintenanceWindow limits when the module makes disruptive changes. Without time ranges or cron entries the
+// schedule is always open. A dependency further limits the window to when a reading of another resource has a value.
+type MaintenanceWindow struct {
+	TimeRanges      []string          `json:"time_ranges"`
+	Cron            []string          `json:"cron"`
+	DurationMinutes float64           `json:"duration_minutes"`
+	Timezone        string            `json:"timezone"`
+	Dependency      *WindowDependency `json:"dependency"`
+}
+
+// WindowDependency keeps the window closed unless the reading of the resource equals the value, e.g. a mission
+// planner reporting {"state": "idle"}
+type WindowDependency struct {
+	Resource string      `json:"resource"`
+	Reading  string      `json:"reading"`
+	Equals   interface{} `json:"equals"`
+}
+
+// timeRange is a daily range in minutes since midnight, end is before start when the range crosses midnight
+type timeRange struct {
+	start int
+	end   int
+}
+
+func (tr timeRange) contains(minute int) bool {
+	if tr.start <= tr.end {
+		return minute >= tr.start && minute < tr.end
+	}
+	return minute >= tr.start || minute < tr.end
+}
+
+func parseTimeRange(s string) (timeRange, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return timeRange{}, fmt.Errorf("%w: %q", ErrInvalidTimeRange, s)
+	}
+	startTime, err := time.Parse("15:04", strings.TrimSpace(start))
+	if err != nil {
+		return timeRange{}, fmt.Errorf("%w: %q", ErrInvalidTimeRange, s)
+	}
+	endTime, err := time.Parse("15:04", strings.TrimSpace(end))
+	if err != nil {
+		return timeRange{}, fmt.Errorf("%w: %q", ErrInvalidTimeRange, s)
+	}
+	return timeRange{
+		start: startTime.Hour()*60 + startTime.Minute(),
+		end:   endTime.Hour()*60 + endTime.Minute(),
+	}, nil
+}
+
+// cronSchedule is a standard 5 field cron expression, each field is a bitset of the values it matches
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// Like cron, when both day fields are restricted a day matches if either of them does
+	dayOfMonthAny, dayOfWeekAny bool
+}
+
+func parseCron(s string) (*cronSchedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCron, s)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCron, s)
+		}
+		bits[i] = b
+	}
+	// Sunday is both 0 and 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronSchedule{
+		minute:        bits[0],
+		hour:          bits[1],
+		dayOfMonth:    bits[2],
+		month:         bits[3],
+		dayOfWeek:     bits[4],
+		dayOfMonthAny: fields[2] == "*",
+		dayOfWeekAny:  fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a comma separated list of *, n, a-b, each optionally followed by /step
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, ErrInvalidCron
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(first); err != nil {
+				return 0, ErrInvalidCron
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					return 0, ErrInvalidCron
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, ErrInvalidCron
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (cs *cronSchedule) matches(t time.Time) bool {
+	if cs.minute&(1<<t.Minute()) == 0 || cs.hour&(1<<t.Hour()) == 0 || cs.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dayOfMonth := cs.dayOfMonth&(1<<t.Day()) != 0
+	dayOfWeek := cs.dayOfWeek&(1<<int(t.Weekday())) != 0
+	if cs.dayOfMonthAny || cs.dayOfWeekAny {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+func (mw *MaintenanceWindow) location() (*time.Location, error) {
+	if mw.Timezone == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(mw.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTimezone, mw.Timezone)
+	}
+	return location, nil
+}
+
+func (mw *MaintenanceWindow) validate() []error {
+	if mw == nil {
+		return nil
+	}
+	var validationErrors []error
+	for _, s := range mw.TimeRanges {
+		if _, err := parseTimeRange(s); err != nil {
+			validationErrors = append(validationErrors, err)
+		}
+	}
+	for _, s := range mw.Cron {
+		if _, err := parseCron(s); err != nil {
+			validationErrors = append(validationErrors, err)
+		}
+	}
+	if len(mw.Cron) > 0 && mw.DurationMinutes <= 0 {
+		validationErrors = append(validationErrors, ErrCronDurationRequired)
+	}
+	if _, err := mw.location(); err != nil {
+		validationErrors = append(validationErrors, err)
+	}
+	if mw.Dependency != nil && (mw.Dependency.Resource == "" || mw.Dependency.Reading == "") {
+		validationErrors = append(validationErrors, ErrWindowDependencyIncomplete)
+	}
+	return validationErrors
+}
+
+// isOpen reports whether t is inside the scheduled time ranges or cron windows
+func (mw *MaintenanceWindow) isOpen(t time.Time) bool {
+	if mw == nil || (len(mw.TimeRanges) == 0 && len(mw.Cron) == 0) {
+		return true
+	}
+	location, err := mw.location()
+	if err != nil {
+		return false
+	}
+	t = t.In(location)
+	minute := t.Hour()*60 + t.Minute()
+	for _, s := range mw.TimeRanges {
+		if tr, err := parseTimeRange(s); err == nil && tr.contains(minute) {
+			return true
+		}
+	}
+
+	// A cron window is open for duration_minutes after each time the expression matches
+	duration := int(mw.DurationMinutes)
+	start := t.Truncate(time.Minute)
+	for _, s := range mw.Cron {
+		cs, err := parseCron(s)
+		if err != nil {
+			continue
+		}
+		for i := 0; i < duration; i++ {
+			if cs.matches(start.Add(-time.Duration(i) * time.Minute)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// windowDependencyFrom finds the resource a maintenance window depends on, by its name
+func windowDependencyFrom(deps resource.Dependencies, name string) (resource.Sensor, error) {
+	for depName, dep := range deps {
+		if depName.ShortName() != name && depName.Name != name {
+			continue
+		}
+		sensor, ok := dep.(resource.Sensor)
+		if !ok {
+			return nil, fmt.Errorf("maintenance_window dependency %s has no readings", name)
+		}
+		return sensor, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrWindowDependencyNotFound, name)
+}
+
+// windowStatus is whether the maintenance window is open for reconfigure, and if not why
+type windowStatus struct {
+	open   bool
+	reason string
+}
+
+// currentWindow checks the maintenance window of the component's current config for reconfigure. The dependency's
+// Readings can take up to windowDependencyTimeout, so it must be called without dc.mu held.
+func (dc *DockerConfig) currentWindow() windowStatus {
+	dc.mu.RLock()
+	conf := dc.conf
+	dc.mu.RUnlock()
+	open, reason := dc.maintenanceWindowOpen(&conf, time.Now())
+	return windowStatus{open: open, reason: reason}
+}
+
+// maintenanceWindowOpen reports whether disruptive changes can be made now, and if not why
+func (dc *DockerConfig) maintenanceWindowOpen(conf *Config, now time.Time) (bool, string) {
+	dc.windowMu.Lock()
+	dependency := dc.windowDependency
+	dc.windowMu.Unlock()
+	return dc.maintenanceWindowOpenWith(conf, dependency, now)
+}
+
+// maintenanceWindowOpenWith is maintenanceWindowOpen for a dependency the component doesn't use yet
+func (dc *DockerConfig) maintenanceWindowOpenWith(conf *Config, dependency resource.Sensor, now time.Time) (bool, string) {
+	dc.windowMu.Lock()
+	held, openUntil := dc.windowHeld, dc.windowOpenUntil
+	dc.windowMu.Unlock()
+
+	if held {
+		return false, "held by the maintenance_window command"
+	}
+	if now.Before(openUntil) {
+		return true, ""
+	}
+	mw := conf.MaintenanceWindow
+	if !mw.isOpen(now) {
+		return false, "outside the maintenance window"
+	}
+	if mw == nil || mw.Dependency == nil {
+		return true, ""
+	}
+	if dependency == nil {
+		return false, fmt.Sprintf("%s: %v", mw.Dependency.Resource, ErrWindowDependencyNotFound)
+	}
+
+	ctx, cancel := context.WithTimeout(dc.cancelCtx, windowDependencyTimeout)
+	defer cancel()
+	readings, err := dependency.Readings(ctx, nil)
+	if err != nil {
+		return false, fmt.Sprintf("unable to get readings of %s: %v", mw.Dependency.Resource, err)
+	}
+	// Readings come back as JSON-like values, compare them the way they print so 1 and 1.0 are equal
+	if value, ok := readings[mw.Dependency.Reading]; !ok || fmt.Sprint(value) != fmt.Sprint(mw.Dependency.Equals) {
+		return false, fmt.Sprintf("waiting for %s %s to be %v", mw.Dependency.Resource, mw.Dependency.Reading, mw.Dependency.Equals)
+	}
+	return true, ""
+}
+
+// applyPendingUpdate applies a deferred update once the maintenance window is open
+func (dc *DockerConfig) applyPendingUpdate() {
+	// The dependency's Readings can take up to windowDependencyTimeout, so it is read without holding dc.mu
+	dc.mu.RLock()
+	conf, pending := dc.conf, !dc.pendingSince.IsZero()
+	dc.mu.RUnlock()
+	if !pending {
+		return
+	}
+	if open, _ := dc.maintenanceWindowOpen(&conf, time.Now()); !open {
+		return
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.pendingSince.IsZero() || dc.cancelCtx.Err() != nil {
+		return
+	}
+	conf = dc.conf
+	if err := dc.reconfigure(&conf, windowStatus{open: true}); err != nil {
+		dc.logger.Errorf("Unable to apply the pending update: %v", err)
+	}
+}
+
+// pendingUpdateStatus returns the deferred update for Readings, or nil if there is none. dc.mu must be held.
+func (dc *DockerConfig) pendingUpdateStatus() map[string]interface{} {
+	if dc.pendingSince.IsZero() {
+		return nil
+	}
+	return map[string]interface{}{
+		"image_name":  dc.conf.ImageName,
+		"repo_digest": dc.conf.RepoDigest,
+		"since":       dc.pendingSince.UTC().Format(time.RFC3339),
+		"reason":      dc.pendingReason,
+	}
+}
+
+// doMaintenanceWindow handles {"command": "maintenance_window", "action": "open", "duration_minutes": 30}. open
+// opens the window now, hold keeps it closed until release, and status only reports the state of the window.
+func (dc *DockerConfig) doMaintenanceWindow(cmd map[string]interface{}) (map[string]interface{}, error) {
+	action, _ := cmd["action"].(string)
+	dc.windowMu.Lock()
+	switch action {
+	case "", "status":
+	case "open":
+		duration := defaultWindowOpenDuration
+		if minutes, ok := cmd["duration_minutes"].(float64); ok && minutes > 0 {
+			duration = time.Duration(minutes * float64(time.Minute))
+		}
+		dc.windowHeld = false
+		dc.windowOpenUntil = time.Now().Add(duration)
+	case "hold":
+		dc.windowHeld = true
+	case "release":
+		dc.windowHeld = false
+		dc.windowOpenUntil = time.Time{}
+	default:
+		dc.windowMu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWindowAction, action)
+	}
+	dc.windowMu.Unlock()
+
+	if action == "open" {
+		dc.applyPendingUpdate()
+	}
+
+	dc.mu.RLock()
+	conf := dc.conf
+	dc.mu.RUnlock()
+	open, reason := dc.maintenanceWindowOpen(&conf, time.Now())
+	status := map[string]interface{}{"open": open}
+	if reason != "" {
+		status["reason"] = reason
+	}
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	if pending := dc.pendingUpdateStatus(); pending != nil {
+		status["pending_update"] = pending
+	}
+	return status, nil
+}
@@ -0,0 +1,208 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/resource"
+)
+
+// fakeSensor is a resource whose readings come from a function
+type fakeSensor struct {
+	resource.Named
+	resource.AlwaysRebuild
+	resource.TriviallyCloseable
+	readings func() map[string]interface{}
+}
+
+func (fs *fakeSensor) Readings(ctx context.Context, extra map[string]interface{}) (map[string]interface{}, error) {
+	return fs.readings(), nil
+}
+
+func (fs *fakeSensor) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func TestMaintenanceWindowSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, s)
+		assert.NoError(t, err)
+		return parsed
+	}
+
+	var mw *MaintenanceWindow
+	assert.True(t, mw.isOpen(at("2024-01-01T12:00:00Z")))
+
+	// Time ranges can cross midnight, and are in the window's timezone
+	mw = &MaintenanceWindow{TimeRanges: []string{"23:00-01:00"}, Timezone: "America/New_York"}
+	assert.True(t, mw.isOpen(at("2024-01-02T04:30:00Z")))
+	assert.False(t, mw.isOpen(at("2024-01-01T23:30:00Z")))
+
+	// 02:00 on weekdays for two hours, 2024-01-01 was a Monday
+	mw = &MaintenanceWindow{Cron: []string{"0 2 * * 1-5"}, DurationMinutes: 120, Timezone: "UTC"}
+	assert.False(t, mw.isOpen(at("2024-01-01T01:59:00Z")))
+	assert.True(t, mw.isOpen(at("2024-01-01T02:00:00Z")))
+	assert.True(t, mw.isOpen(at("2024-01-01T03:59:00Z")))
+	assert.False(t, mw.isOpen(at("2024-01-01T04:00:00Z")))
+	assert.False(t, mw.isOpen(at("2024-01-06T02:30:00Z")))
+
+	// Every 15 minutes, for 5 minutes
+	mw = &MaintenanceWindow{Cron: []string{"*/15 * * * *"}, DurationMinutes: 5, Timezone: "UTC"}
+	assert.True(t, mw.isOpen(at("2024-01-01T10:34:00Z")))
+	assert.False(t, mw.isOpen(at("2024-01-01T10:40:00Z")))
+}
+
+func TestValidateMaintenanceWindow(t *testing.T) {
+	conf := validRunConfig()
+	conf.MaintenanceWindow = &MaintenanceWindow{
+		Cron:       []string{"0 2 * *", "61 * * * *"},
+		Dependency: &WindowDependency{Resource: "mission"},
+	}
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrInvalidCron)
+	assert.ErrorIs(t, err, ErrCronDurationRequired)
+	assert.ErrorIs(t, err, ErrWindowDependencyIncomplete)
+
+	conf.MaintenanceWindow = &MaintenanceWindow{
+		Cron:            []string{"30 1 * * 0,6"},
+		DurationMinutes: 60,
+		Dependency:      &WindowDependency{Resource: "mission", Reading: "state", Equals: "idle"},
+	}
+	deps, err := conf.Validate("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mission"}, deps)
+}
+
+func TestUpdatesWaitForMaintenanceWindow(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+
+	state := "running"
+	mission := &fakeSensor{Named: sensor.Named("mission").AsNamed(), readings: func() map[string]interface{} {
+		return map[string]interface{}{"state": state}
+	}}
+	dependency, err := windowDependencyFrom(resource.Dependencies{sensor.Named("mission"): mission}, "mission")
+	assert.NoError(t, err)
+	dc.windowDependency = dependency
+
+	window := &MaintenanceWindow{Dependency: &WindowDependency{Resource: "mission", Reading: "state", Equals: "idle"}}
+	running := Config{ImageName: "ubuntu", RepoDigest: "sha256:old", RunOptions: &RunOptions{}, MaintenanceWindow: window}
+	dc.conf, dc.appliedConf = running, running
+	dc.containers = []DockerContainer{&fakeDockerContainer{id: "old"}}
+
+	// The mission is running, so the new digest waits
+	update := running
+	update.RepoDigest = "sha256:new"
+	update.DownloadOnly = true
+	current := dc.currentWindow()
+	dc.mu.Lock()
+	assert.NoError(t, dc.reconfigure(&update, current))
+	dc.conf = update
+	dc.mu.Unlock()
+	assert.Empty(t, manager.stoppedContainers)
+
+	readings, err := dc.Readings(context.Background(), nil)
+	assert.NoError(t, err)
+	pending := readings["pending_update"].(map[string]interface{})
+	assert.Equal(t, "sha256:new", pending["repo_digest"])
+	assert.Equal(t, "waiting for mission state to be idle", pending["reason"])
+
+	// Holding the window keeps it closed even once the mission is idle
+	state = "idle"
+	status, err := dc.DoCommand(context.Background(), map[string]interface{}{"command": "maintenance_window", "action": "hold"})
+	assert.NoError(t, err)
+	assert.Equal(t, false, status["open"])
+	dc.applyPendingUpdate()
+	assert.Empty(t, manager.stoppedContainers)
+
+	status, err = dc.DoCommand(context.Background(), map[string]interface{}{"command": "maintenance_window", "action": "release"})
+	assert.NoError(t, err)
+	assert.Equal(t, true, status["open"])
+	dc.applyPendingUpdate()
+	assert.Equal(t, []string{"old"}, manager.stoppedContainers)
+	assert.Equal(t, "sha256:new", dc.appliedConf.RepoDigest)
+
+	readings, err = dc.Readings(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Nil(t, readings["pending_update"])
+}
+
+func TestMaintenanceWindowStatusDoesNotHoldLock(t *testing.T) {
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	asked, release := make(chan struct{}), make(chan struct{})
+	mission := &fakeSensor{Named: sensor.Named("mission").AsNamed(), readings: func() map[string]interface{} {
+		close(asked)
+		<-release
+		return map[string]interface{}{"state": "idle"}
+	}}
+	dependency, err := windowDependencyFrom(resource.Dependencies{sensor.Named("mission"): mission}, "mission")
+	assert.NoError(t, err)
+	dc.windowDependency = dependency
+	dc.conf = Config{MaintenanceWindow: &MaintenanceWindow{Dependency: &WindowDependency{Resource: "mission", Reading: "state", Equals: "idle"}}}
+
+	done := make(chan map[string]interface{})
+	go func() {
+		status, err := dc.DoCommand(context.Background(), map[string]interface{}{"command": "maintenance_window"})
+		assert.NoError(t, err)
+		done <- status
+	}()
+	<-asked
+
+	// A slow dependency doesn't block Reconfigure
+	locked := make(chan struct{})
+	go func() {
+		dc.mu.Lock()
+		dc.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("dc.mu was held while reading the maintenance window dependency")
+	}
+	close(release)
+	assert.Equal(t, true, (<-done)["open"])
+}
+
+func TestReconfigureReadsWindowDependencyWithoutLock(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	asked, release := make(chan struct{}), make(chan struct{})
+	mission := &fakeSensor{Named: sensor.Named("mission").AsNamed(), readings: func() map[string]interface{} {
+		close(asked)
+		<-release
+		return map[string]interface{}{"state": "idle"}
+	}}
+	conf := validRunConfig()
+	conf.MaintenanceWindow = &MaintenanceWindow{Dependency: &WindowDependency{Resource: "mission", Reading: "state", Equals: "idle"}}
+	manager.images[conf.RepoDigest] = true
+
+	done := make(chan error)
+	go func() {
+		done <- dc.Reconfigure(context.Background(), resource.Dependencies{sensor.Named("mission"): mission}, resource.Config{
+			Name:                "container0",
+			API:                 sensor.API,
+			ConvertedAttributes: conf,
+		})
+	}()
+	<-asked
+
+	// A slow dependency doesn't block Readings, DoCommand or Close
+	locked := make(chan struct{})
+	go func() {
+		dc.mu.Lock()
+		dc.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("dc.mu was held while reading the maintenance window dependency")
+	}
+	close(release)
+	assert.NoError(t, <-done)
+	assert.NoError(t, dc.Close(context.Background()))
+}
@@ -0,0 +1,243 @@
+package docker_deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+var ErrNetworkNameRequired = errors.New("networks require a name")
+var ErrNetworkDuplicate = errors.New("network is listed more than once")
+var ErrUnsupportedNetworkDriver = errors.New("network driver must be bridge or macvlan")
+var ErrMacvlanParentRequired = errors.New("macvlan networks require a parent interface")
+var ErrInvalidSubnet = errors.New("network subnet must be a CIDR, e.g. 172.28.0.0/16")
+var ErrAddressOutsideSubnet = errors.New("network address must be an IP inside the subnet")
+var ErrStaticIPRequiresSubnet = errors.New("ipv4_address requires the network's subnet")
+var ErrNetworksWithNetworkMode = errors.New("host_options.NetworkMode must be one of the networks when networks are set")
+var ErrNetworkMismatch = errors.New("a network with the same name but different settings already exists")
+
+const (
+	NetworkDriverBridge  = "bridge"
+	NetworkDriverMacvlan = "macvlan"
+)
+
+// Networks, volumes and containers the module created carry this label, the module never removes anything it didn't
+// create
+const managedLabel = "com.viam.docker-manager.managed"
+
+// Volumes and containers also carry the component that created them
+const componentLabel = "com.viam.docker-manager.component"
+
+// NetworkOptions describes a user-defined network a container is attached to. The network is created the first time
+// a component needs it, and removed once no component of the module uses it.
+type NetworkOptions struct {
+	Name        string            `json:"name"`
+	Driver      string            `json:"driver"`
+	Subnet      string            `json:"subnet"`
+	Gateway     string            `json:"gateway"`
+	Parent      string            `json:"parent"`
+	Labels      map[string]string `json:"labels"`
+	Aliases     []string          `json:"aliases"`
+	IPv4Address string            `json:"ipv4_address"`
+}
+
+func (no *NetworkOptions) driver() string {
+	if no.Driver == "" {
+		return NetworkDriverBridge
+	}
+	return no.Driver
+}
+
+func (no *NetworkOptions) validate() []error {
+	if no.Name == "" {
+		return []error{ErrNetworkNameRequired}
+	}
+	var validationErrors []error
+	wrap := func(err error) error {
+		return fmt.Errorf("network %s: %w", no.Name, err)
+	}
+	switch no.driver() {
+	case NetworkDriverBridge:
+	case NetworkDriverMacvlan:
+		if no.Parent == "" {
+			validationErrors = append(validationErrors, wrap(ErrMacvlanParentRequired))
+		}
+	default:
+		validationErrors = append(validationErrors, wrap(fmt.Errorf("%w: %q", ErrUnsupportedNetworkDriver, no.Driver)))
+	}
+
+	var subnet *net.IPNet
+	if no.Subnet != "" {
+		var err error
+		if _, subnet, err = net.ParseCIDR(no.Subnet); err != nil {
+			return append(validationErrors, wrap(fmt.Errorf("%w: %q", ErrInvalidSubnet, no.Subnet)))
+		}
+	}
+	for _, address := range []string{no.Gateway, no.IPv4Address} {
+		if address == "" {
+			continue
+		}
+		if subnet == nil {
+			if address == no.IPv4Address {
+				validationErrors = append(validationErrors, wrap(ErrStaticIPRequiresSubnet))
+			}
+			continue
+		}
+		if ip := net.ParseIP(address); ip == nil || !subnet.Contains(ip) {
+			validationErrors = append(validationErrors, wrap(fmt.Errorf("%w: %q", ErrAddressOutsideSubnet, address)))
+		}
+	}
+	return validationErrors
+}
+
+// matches checks that an existing network can be used for these options
+func (no *NetworkOptions) matches(driver string, subnets []string) error {
+	if driver != no.driver() {
+		return fmt.Errorf("network %s uses the %s driver, not %s: %w", no.Name, driver, no.driver(), ErrNetworkMismatch)
+	}
+	if no.Subnet == "" {
+		return nil
+	}
+	for _, subnet := range subnets {
+		if subnet == no.Subnet {
+			return nil
+		}
+	}
+	return fmt.Errorf("network %s doesn't have the subnet %s: %w", no.Name, no.Subnet, ErrNetworkMismatch)
+}
+
+// labels returns the labels of the network, the managed label is set last so it can't be overridden
+func (no *NetworkOptions) labels() map[string]string {
+	labels := map[string]string{}
+	for k, v := range no.Labels {
+		labels[k] = v
+	}
+	labels[managedLabel] = "true"
+	return labels
+}
+
+// endpointSettings returns the settings of the container's endpoint on the network
+func (no *NetworkOptions) endpointSettings() *network.EndpointSettings {
+	settings := &network.EndpointSettings{Aliases: no.Aliases}
+	if no.IPv4Address != "" {
+		settings.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: no.IPv4Address}
+	}
+	return settings
+}
+
+func validateNetworks(conf *Config) []error {
+	if conf.RunOptions == nil || len(conf.RunOptions.Networks) == 0 {
+		return nil
+	}
+	var validationErrors []error
+	names := map[string]bool{}
+	for _, n := range conf.RunOptions.Networks {
+		validationErrors = append(validationErrors, n.validate()...)
+		if names[n.Name] {
+			validationErrors = append(validationErrors, fmt.Errorf("%s: %w", n.Name, ErrNetworkDuplicate))
+		}
+		names[n.Name] = true
+	}
+	if mode, ok := conf.RunOptions.HostOptions["NetworkMode"].(string); ok && mode != "" && !names[mode] {
+		validationErrors = append(validationErrors, fmt.Errorf("%w: %q", ErrNetworksWithNetworkMode, mode))
+	}
+	return validationErrors
+}
+
+// primaryNetwork returns the network the container is created on, the one named by NetworkMode or else the first.
+// The container is connected to the others once it has been created.
+func primaryNetwork(runOptions *RunOptions) *NetworkOptions {
+	if len(runOptions.Networks) == 0 {
+		return nil
+	}
+	mode, _ := runOptions.HostOptions["NetworkMode"].(string)
+	for i := range runOptions.Networks {
+		if runOptions.Networks[i].Name == mode {
+			return &runOptions.Networks[i]
+		}
+	}
+	return &runOptions.Networks[0]
+}
+
+func networkNames(conf *Config) []string {
+	networks, err := conf.networks()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(networks))
+	for _, n := range networks {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+// The networks used by every component of the module
+var moduleNetworks = &networkRegistry{byComponent: map[string][]string{}}
+
+type networkRegistry struct {
+	mu          sync.Mutex
+	byComponent map[string][]string
+}
+
+// set records the networks a component uses, and returns the networks it stopped using that no other component uses
+func (nr *networkRegistry) set(component string, names []string) []string {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	previous := nr.byComponent[component]
+	nr.byComponent[component] = names
+	return nr.unused(previous)
+}
+
+// remove forgets a component, and returns the networks it used that no other component uses
+func (nr *networkRegistry) remove(component string) []string {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	previous := nr.byComponent[component]
+	delete(nr.byComponent, component)
+	return nr.unused(previous)
+}
+
+func (nr *networkRegistry) unused(names []string) []string {
+	var unused []string
+	for _, name := range names {
+		used := false
+		for _, components := range nr.byComponent {
+			for _, n := range components {
+				used = used || n == name
+			}
+		}
+		if !used {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+// ensureNetworks creates the networks of the config that don't exist yet
+func (dc *DockerConfig) ensureNetworks(ctx context.Context, conf *Config) error {
+	networks, err := conf.networks()
+	if err != nil {
+		return err
+	}
+	for i := range networks {
+		if err := dc.manager.EnsureNetwork(ctx, &networks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseNetworks removes networks no component uses any more. A network that can't be removed is left in place.
+func (dc *DockerConfig) releaseNetworks(names []string) {
+	for _, name := range names {
+		if err := dc.manager.RemoveNetwork(context.Background(), name); err != nil {
+			dc.logger.Warnf("Unable to remove network %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNetworks(t *testing.T) {
+	conf := validRunConfig()
+	conf.RunOptions.Networks = []NetworkOptions{
+		{Name: "backend", Subnet: "172.28.0.0/16", Gateway: "172.28.0.1", Aliases: []string{"db"}, IPv4Address: "172.28.0.10"},
+		{Name: "lan", Driver: "macvlan", Parent: "eth0"},
+	}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	conf.RunOptions.Networks = []NetworkOptions{
+		{Name: "backend", Subnet: "172.28.0.0/16", IPv4Address: "10.0.0.10"},
+		{Name: "backend"},
+		{Name: "lan", Driver: "macvlan"},
+		{Name: "overlay", Driver: "overlay"},
+		{Name: "static", IPv4Address: "172.28.0.10"},
+		{Name: "bad", Subnet: "172.28.0.0"},
+		{},
+	}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrAddressOutsideSubnet)
+	assert.ErrorIs(t, err, ErrNetworkDuplicate)
+	assert.ErrorIs(t, err, ErrMacvlanParentRequired)
+	assert.ErrorIs(t, err, ErrUnsupportedNetworkDriver)
+	assert.ErrorIs(t, err, ErrStaticIPRequiresSubnet)
+	assert.ErrorIs(t, err, ErrInvalidSubnet)
+	assert.ErrorIs(t, err, ErrNetworkNameRequired)
+
+	conf.RunOptions.Networks = []NetworkOptions{{Name: "backend"}, {Name: "frontend"}}
+	conf.RunOptions.HostOptions = map[string]interface{}{"Binds": "data:/data", "NetworkMode": "host", "AutoRemove": false}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrNetworksWithNetworkMode)
+
+	conf.RunOptions.HostOptions["NetworkMode"] = "frontend"
+	_, err = conf.Validate("")
+	assert.NoError(t, err)
+	assert.Equal(t, "frontend", primaryNetwork(conf.RunOptions).Name)
+}
+
+func TestNetworksAreReferenceCounted(t *testing.T) {
+	registry := &networkRegistry{byComponent: map[string][]string{}}
+	assert.Empty(t, registry.set("api", []string{"backend", "frontend"}))
+	assert.Empty(t, registry.set("db", []string{"backend"}))
+
+	// frontend is only used by api
+	assert.Equal(t, []string{"frontend"}, registry.set("api", []string{"backend"}))
+	assert.Empty(t, registry.remove("api"))
+	assert.Equal(t, []string{"backend"}, registry.remove("db"))
+}
+
+func TestEnsureNetworks(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	conf := validRunConfig()
+	conf.RunOptions.Networks = []NetworkOptions{{Name: "backend", Subnet: "172.28.0.0/16"}}
+	assert.NoError(t, dc.ensureNetworks(context.Background(), conf))
+	assert.Contains(t, manager.networks, "backend")
+
+	// Another component expecting a different network under the same name
+	other := validRunConfig()
+	other.RunOptions.Networks = []NetworkOptions{{Name: "backend", Subnet: "10.10.0.0/16"}}
+	assert.ErrorIs(t, dc.ensureNetworks(context.Background(), other), ErrNetworkMismatch)
+
+	dc.releaseNetworks([]string{"backend"})
+	assert.Equal(t, []string{"backend"}, manager.removedNetworks)
+	assert.NotContains(t, manager.networks, "backend")
+}
+
+func TestNetworkLabelsKeepManagedLabel(t *testing.T) {
+	options := &NetworkOptions{Name: "backend", Labels: map[string]string{managedLabel: "false", "team": "robots"}}
+	assert.Equal(t, map[string]string{managedLabel: "true", "team": "robots"}, options.labels())
+}
@@ -0,0 +1,151 @@
+package docker_deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidPlatform = errors.New("platforms must look like os/architecture or os/architecture/variant")
+var ErrPlatformMismatch = errors.New("image platform does not match the host")
+
+// Platform is the OS and CPU architecture an image is built for, as it appears in image indexes
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+	}
+	return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+}
+
+// Matches reports whether an image for p runs on other. An empty variant matches any variant.
+func (p Platform) Matches(other Platform) bool {
+	a, b := p.normalize(), other.normalize()
+	if a.OS != b.OS || a.Architecture != b.Architecture {
+		return false
+	}
+	return a.Variant == "" || b.Variant == "" || a.Variant == b.Variant
+}
+
+// normalize maps the names reported by uname and the Docker daemon to the names used in image indexes
+func (p Platform) normalize() Platform {
+	p.OS = strings.ToLower(p.OS)
+	switch strings.ToLower(p.Architecture) {
+	case "x86_64", "x86-64", "amd64":
+		p.Architecture = "amd64"
+	case "aarch64", "arm64":
+		p.Architecture = "arm64"
+		// v8 is the only arm64 variant in use, indexes usually leave it out
+		if p.Variant == "v8" {
+			p.Variant = ""
+		}
+	case "armhf", "armv7", "armv7l":
+		p.Architecture, p.Variant = "arm", "v7"
+	case "armel", "armv6", "armv6l":
+		p.Architecture, p.Variant = "arm", "v6"
+	case "i386", "i686", "386":
+		p.Architecture = "386"
+	}
+	return p
+}
+
+// parsePlatform parses a platform such as linux/arm64 or linux/arm/v7
+func parsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("%w: %q", ErrInvalidPlatform, s)
+	}
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// digestForPlatform picks the digest for the host from a map keyed by platform. A key that matches the host exactly,
+// variant included, is preferred, otherwise the first compatible key in sorted order is used so the pick is stable.
+func digestForPlatform(digests map[string]string, host Platform) (string, bool) {
+	keys := make([]string, 0, len(digests))
+	for key := range digests {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	found := ""
+	for _, key := range keys {
+		platform, err := parsePlatform(key)
+		if err != nil || !platform.Matches(host) {
+			continue
+		}
+		if platform.normalize() == host.normalize() {
+			return digests[key], true
+		}
+		if found == "" {
+			found = digests[key]
+		}
+	}
+	return found, found != ""
+}
+
+// getHostPlatform returns the platform of the Docker daemon, it is only asked once. dc.mu must be held.
+func (dc *DockerConfig) getHostPlatform(ctx context.Context, conf *Config) (Platform, error) {
+	if dc.hostPlatform != nil {
+		return *dc.hostPlatform, nil
+	}
+	// Reconfigure sets the credentials once they are resolved
+	if err := dc.ensureManager(conf, NewRegistryAuth(nil, "")); err != nil {
+		return Platform{}, err
+	}
+	platform, err := dc.manager.HostPlatform(ctx)
+	if err != nil {
+		return Platform{}, err
+	}
+	dc.hostPlatform = &platform
+	return platform, nil
+}
+
+// pinPlatformDigest sets repo_digest to the digest of platform_digests for the host, repo_digest is used for
+// platforms that aren't in the map
+func (dc *DockerConfig) pinPlatformDigest(ctx context.Context, newConf *Config) (*Config, error) {
+	if len(newConf.PlatformDigests) == 0 {
+		return newConf, nil
+	}
+	host, err := dc.getHostPlatform(ctx, newConf)
+	if err != nil {
+		return nil, err
+	}
+	digest, ok := digestForPlatform(newConf.PlatformDigests, host)
+	if !ok {
+		if newConf.RepoDigest == "" {
+			return nil, fmt.Errorf("%w: platform_digests has no digest for %s", ErrPlatformMismatch, host)
+		}
+		return newConf, nil
+	}
+	pinned := *newConf
+	pinned.RepoDigest = digest
+	return &pinned, nil
+}
+
+// checkImagePlatform makes sure a local image can run on the host, a digest of a single platform manifest for
+// another architecture pulls fine but its containers fail with `exec format error`
+func (dc *DockerConfig) checkImagePlatform(ctx context.Context, image PinnedImage) error {
+	host, err := dc.manager.HostPlatform(ctx)
+	if err != nil {
+		return err
+	}
+	platform, err := dc.manager.ImagePlatform(ctx, image)
+	if err != nil {
+		return err
+	}
+	if !platform.Matches(host) {
+		return fmt.Errorf("%w: %s is built for %s but the host is %s", ErrPlatformMismatch, image, platform, host)
+	}
+	return nil
+}
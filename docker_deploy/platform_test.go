@@ -0,0 +1,84 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlatformMatches(t *testing.T) {
+	host := Platform{OS: "linux", Architecture: "aarch64"}
+	assert.True(t, Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}.Matches(host))
+	assert.False(t, Platform{OS: "linux", Architecture: "amd64"}.Matches(host))
+
+	host = Platform{OS: "linux", Architecture: "armv7l"}
+	assert.True(t, Platform{OS: "linux", Architecture: "arm"}.Matches(host))
+	assert.True(t, Platform{OS: "linux", Architecture: "arm", Variant: "v7"}.Matches(host))
+	assert.False(t, Platform{OS: "linux", Architecture: "arm", Variant: "v6"}.Matches(host))
+}
+
+func TestDigestForPlatform(t *testing.T) {
+	digests := map[string]string{
+		"linux/amd64":  "sha256:amd64",
+		"linux/arm64":  "sha256:arm64",
+		"linux/arm":    "sha256:arm",
+		"linux/arm/v7": "sha256:armv7",
+	}
+	for host, expected := range map[Platform]string{
+		{OS: "linux", Architecture: "x86_64"}:  "sha256:amd64",
+		{OS: "linux", Architecture: "aarch64"}: "sha256:arm64",
+		{OS: "linux", Architecture: "armv7l"}:  "sha256:armv7",
+		{OS: "linux", Architecture: "armv6l"}:  "sha256:arm",
+	} {
+		digest, ok := digestForPlatform(digests, host)
+		assert.True(t, ok, host.String())
+		assert.Equal(t, expected, digest, host.String())
+	}
+	_, ok := digestForPlatform(digests, Platform{OS: "linux", Architecture: "riscv64"})
+	assert.False(t, ok)
+
+	// A host without a variant matches every variant, the exact key wins and otherwise the pick is stable
+	arm := Platform{OS: "linux", Architecture: "arm"}
+	for i := 0; i < 20; i++ {
+		digest, _ := digestForPlatform(digests, arm)
+		assert.Equal(t, "sha256:arm", digest)
+		digest, _ = digestForPlatform(map[string]string{"linux/arm/v7": "sha256:armv7", "linux/arm/v6": "sha256:armv6"}, arm)
+		assert.Equal(t, "sha256:armv6", digest)
+	}
+}
+
+func TestPlatformDigests(t *testing.T) {
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	conf := &Config{
+		ImageName:       "ghcr.io/viam-soleng/app",
+		PlatformDigests: map[string]string{"linux/amd64": "sha256:amd64", "linux/arm64": "sha256:arm64"},
+		RunOptions:      &RunOptions{},
+	}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	pinned, err := dc.pinPlatformDigest(context.Background(), conf)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:arm64", pinned.RepoDigest)
+	assert.Empty(t, conf.RepoDigest)
+
+	conf.PlatformDigests = map[string]string{"linux/amd64": "sha256:amd64"}
+	_, err = dc.pinPlatformDigest(context.Background(), conf)
+	assert.ErrorIs(t, err, ErrPlatformMismatch)
+
+	conf.PlatformDigests["linux"] = "latest"
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrInvalidPlatform)
+	assert.ErrorIs(t, err, ErrPlatformDigestInvalid)
+}
+
+func TestPulledImageForWrongPlatform(t *testing.T) {
+	manager := newFakeDockerManager()
+	manager.imagePlatforms = map[string]Platform{"sha256:amd64": {OS: "linux", Architecture: "amd64"}}
+	dc := newTestDockerConfig(t, manager)
+
+	err := dc.ensureImage(context.Background(), PinnedImage{ImageName: "ubuntu", RepoDigest: "sha256:amd64"}, &Config{})
+	assert.ErrorIs(t, err, ErrPlatformMismatch)
+	assert.ErrorContains(t, err, "ubuntu@sha256:amd64 is built for linux/amd64 but the host is linux/arm64")
+}
@@ -0,0 +1,310 @@
+package docker_deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	compose_types "github.com/compose-spec/compose-go/types"
+)
+
+var ErrPolicyViolation = errors.New("denied by the module policy")
+var ErrInvalidPolicy = errors.New("invalid module policy")
+
+const (
+	// policyFileEnv is a module env variable with the path of the policy file
+	policyFileEnv = "VIAM_DOCKER_POLICY_FILE"
+	// Without policyFileEnv the policy is read from this file in VIAM_MODULE_DATA, if it exists
+	defaultPolicyFile = "docker-policy.json"
+)
+
+// Policy restricts what the components of the module may run. It is set for the whole module by whoever manages the
+// robot, so that config editors can't grant containers access to the host.
+type Policy struct {
+	AllowedRegistries   []string `json:"allowed_registries"`
+	AllowPrivileged     bool     `json:"allow_privileged"`
+	AllowHostNamespaces bool     `json:"allow_host_namespaces"`
+	DeniedCapabilities  []string `json:"denied_capabilities"`
+	AllowedBindPrefixes []string `json:"allowed_bind_prefixes"`
+}
+
+// Host options that share a namespace with the host when set to "host"
+var hostNamespaceOptions = []string{"NetworkMode", "PidMode", "IpcMode", "UTSMode", "UsernsMode", "CgroupnsMode"}
+
+// policyPath returns the path of the policy file, or "" if there is none
+func policyPath() (string, error) {
+	if path := os.Getenv(policyFileEnv); path != "" {
+		return path, nil
+	}
+	dir, err := moduleDataDirectory()
+	if err != nil {
+		return "", nil
+	}
+	path := filepath.Join(dir, defaultPolicyFile)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return path, nil
+}
+
+// loadPolicy reads the module policy, it returns nil if there is no policy. It is read every time so a new policy
+// applies without restarting the module. A policy that can't be read denies everything rather than nothing.
+func loadPolicy() (*Policy, error) {
+	path, err := policyPath()
+	if err != nil || path == "" {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	var policy Policy
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPolicy, path, err)
+	}
+	return &policy, nil
+}
+
+// policyViolations checks the config against the module policy
+func (conf *Config) policyViolations() []error {
+	policy, err := loadPolicy()
+	if err != nil {
+		return []error{err}
+	}
+	if policy == nil {
+		return nil
+	}
+	violations := policy.checkImage(conf.ImageName)
+	if conf.RunOptions != nil {
+		violations = append(violations, policy.checkHostOptions(conf.RunOptions.HostOptions)...)
+	}
+	if conf.ComposeOptions != nil {
+		// Compose files that don't parse are reported when the containers are created
+		if project, err := loadComposeProject(conf.ImageName, conf.ComposeOptions.ComposeFile); err == nil {
+			violations = append(violations, policy.checkComposeProject(project)...)
+		}
+	}
+	if volumes, err := conf.volumes(); err == nil {
+		violations = append(violations, policy.checkVolumes(volumes)...)
+	}
+	violations = append(violations, policy.checkSecretsDirectory(conf.SecretsDirectory)...)
+	return violations
+}
+
+// enforcePolicy is the last check before containers are created, it applies to configs validated before the policy
+// changed too
+func enforcePolicy(check func(policy *Policy) []error) error {
+	policy, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return nil
+	}
+	return errors.Join(check(policy)...)
+}
+
+func policyViolation(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrPolicyViolation, fmt.Sprintf(format, args...))
+}
+
+func (p *Policy) checkImage(imageName string) []error {
+	if len(p.AllowedRegistries) == 0 {
+		return nil
+	}
+	host, err := registryHost(imageName)
+	if err != nil {
+		return []error{err}
+	}
+	for _, allowed := range p.AllowedRegistries {
+		if normalizeRegistryHost(allowed) == host {
+			return nil
+		}
+	}
+	return []error{policyViolation("image %s is from registry %s, which is not in allowed_registries", imageName, host)}
+}
+
+func (p *Policy) checkHostOptions(hostOptions map[string]interface{}) []error {
+	var violations []error
+	if privileged, _ := hostOptions["Privileged"].(bool); privileged && !p.AllowPrivileged {
+		violations = append(violations, policyViolation("host_options.Privileged is not allowed"))
+	}
+	for _, key := range hostNamespaceOptions {
+		if mode, _ := hostOptions[key].(string); mode == "host" && !p.AllowHostNamespaces {
+			violations = append(violations, policyViolation("host_options.%s %q shares a namespace with the host", key, mode))
+		}
+	}
+	for _, capability := range optionList(hostOptions["CapAdd"]) {
+		if p.capabilityDenied(capability) {
+			violations = append(violations, policyViolation("host_options.CapAdd %s is denied", capability))
+		}
+	}
+	for _, bind := range optionList(hostOptions["Binds"]) {
+		source, _, _ := strings.Cut(bind, ":")
+		if !p.bindAllowed(source) {
+			violations = append(violations, policyViolation("host_options.Binds %s is not under allowed_bind_prefixes", source))
+		}
+	}
+	return violations
+}
+
+func (p *Policy) checkComposeProject(project *compose_types.Project) []error {
+	var violations []error
+	for _, service := range project.Services {
+		for _, err := range p.checkImage(service.Image) {
+			violations = append(violations, fmt.Errorf("service %s: %w", service.Name, err))
+		}
+		if service.Privileged && !p.AllowPrivileged {
+			violations = append(violations, policyViolation("service %s: privileged is not allowed", service.Name))
+		}
+		if !p.AllowHostNamespaces {
+			for _, option := range [][2]string{
+				{"network_mode", service.NetworkMode},
+				{"pid", service.Pid},
+				{"ipc", service.Ipc},
+				{"uts", service.Uts},
+				{"userns_mode", service.UserNSMode},
+				{"cgroup", service.Cgroup},
+			} {
+				if option[1] == "host" {
+					violations = append(violations, policyViolation("service %s: %s %q shares a namespace with the host", service.Name, option[0], option[1]))
+				}
+			}
+		}
+		for _, capability := range service.CapAdd {
+			if p.capabilityDenied(capability) {
+				violations = append(violations, policyViolation("service %s: cap_add %s is denied", service.Name, capability))
+			}
+		}
+		for _, volume := range service.Volumes {
+			if volume.Type == compose_types.VolumeTypeBind && !p.bindAllowed(volume.Source) {
+				violations = append(violations, policyViolation("service %s: bind mount %s is not under allowed_bind_prefixes", service.Name, volume.Source))
+			}
+		}
+	}
+	return violations
+}
+
+// checkVolumes checks volumes of the local driver that bind mount a host directory, e.g. driver_opts
+// {"type": "none", "o": "bind", "device": "/"}, against allowed_bind_prefixes like any other bind mount
+func (p *Policy) checkVolumes(volumes []VolumeOptions) []error {
+	if len(p.AllowedBindPrefixes) == 0 {
+		return nil
+	}
+	var violations []error
+	for _, v := range volumes {
+		if !volumeBindsHostPath(v.DriverOpts) {
+			continue
+		}
+		// The daemon resolves a relative device against its own working directory
+		device := v.DriverOpts["device"]
+		if !filepath.IsAbs(device) || !p.bindAllowed(device) {
+			violations = append(violations, policyViolation("volume %s: bind device %s is not under allowed_bind_prefixes", v.Name, device))
+		}
+	}
+	return violations
+}
+
+// checkSecretsDirectory keeps secrets_dir, whose files can be read into the container's env, to VIAM_MODULE_DATA or
+// allowed_bind_prefixes, as if it were bind mounted
+func (p *Policy) checkSecretsDirectory(dir string) []error {
+	if dir == "" || len(p.AllowedBindPrefixes) == 0 {
+		return nil
+	}
+	if moduleDirectory, err := moduleDataDirectory(); err == nil && pathUnder(resolvePath(dir), resolvePath(moduleDirectory)) {
+		return nil
+	}
+	if !filepath.IsAbs(dir) || !p.bindAllowed(dir) {
+		return []error{policyViolation("secrets_dir %s is not in VIAM_MODULE_DATA or under allowed_bind_prefixes", dir)}
+	}
+	return nil
+}
+
+// volumeBindsHostPath reports whether the driver options mount the device with bind or rbind
+func volumeBindsHostPath(driverOpts map[string]string) bool {
+	for _, option := range strings.Split(driverOpts["o"], ",") {
+		if option = strings.TrimSpace(option); option == "bind" || option == "rbind" {
+			return true
+		}
+	}
+	return false
+}
+
+// capabilityDenied compares capabilities without the CAP_ prefix or case, e.g. CAP_SYS_ADMIN and sys_admin. Adding
+// ALL adds every denied capability.
+func (p *Policy) capabilityDenied(capability string) bool {
+	capability = normalizeCapability(capability)
+	for _, denied := range p.DeniedCapabilities {
+		denied = normalizeCapability(denied)
+		if denied == "ALL" || capability == "ALL" || denied == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeCapability(capability string) string {
+	return strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(capability)), "CAP_")
+}
+
+// bindAllowed reports whether a bind source is allowed. Named volumes aren't host paths and are always allowed. Symlinks
+// are resolved first, so a link under an allowed prefix can't point out of it.
+func (p *Policy) bindAllowed(source string) bool {
+	if len(p.AllowedBindPrefixes) == 0 || !filepath.IsAbs(source) {
+		return true
+	}
+	source = resolvePath(source)
+	for _, prefix := range p.AllowedBindPrefixes {
+		if pathUnder(source, resolvePath(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathUnder reports whether path is dir or inside it, both must be clean
+func pathUnder(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// resolvePath cleans the path and resolves the symlinks of the longest part of it that exists. The daemon creates
+// missing bind sources, so what doesn't exist yet is created wherever the existing part points.
+func resolvePath(path string) string {
+	path = filepath.Clean(path)
+	missing := ""
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, missing)
+		}
+		if dir == filepath.Dir(dir) {
+			return path
+		}
+		missing = filepath.Join(filepath.Base(dir), missing)
+	}
+}
+
+// optionList reads a host option that can be a comma separated string or a list
+func optionList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return strings.Split(v, ",")
+	case []string:
+		return v
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	}
+	return nil
+}
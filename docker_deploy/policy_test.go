@@ -0,0 +1,172 @@
+package docker_deploy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writePolicy installs a module policy for the test
+func writePolicy(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), defaultPolicyFile)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	t.Setenv(policyFileEnv, path)
+}
+
+func TestPolicyHostOptions(t *testing.T) {
+	writePolicy(t, `{
+		"allowed_registries": ["docker.io", "ghcr.io"],
+		"denied_capabilities": ["CAP_SYS_ADMIN", "net_admin"],
+		"allowed_bind_prefixes": ["/home/viam/data"]
+	}`)
+
+	conf := validRunConfig()
+	conf.RunOptions.HostOptions = map[string]interface{}{
+		"Binds":       "/home/viam/data/app:/data,cache:/cache",
+		"NetworkMode": "bridge",
+		"AutoRemove":  false,
+	}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	conf.RunOptions.HostOptions["Binds"] = "/:/host,/home/viam/database:/db"
+	conf.RunOptions.HostOptions["NetworkMode"] = "host"
+	conf.RunOptions.HostOptions["Privileged"] = true
+	conf.RunOptions.HostOptions["CapAdd"] = []interface{}{"SYS_ADMIN", "SYS_TIME"}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrPolicyViolation)
+	assert.ErrorContains(t, err, "host_options.Privileged is not allowed")
+	assert.ErrorContains(t, err, `host_options.NetworkMode "host" shares a namespace with the host`)
+	assert.ErrorContains(t, err, "host_options.CapAdd SYS_ADMIN is denied")
+	assert.NotContains(t, err.Error(), "SYS_TIME")
+	assert.ErrorContains(t, err, "host_options.Binds / is not under allowed_bind_prefixes")
+	assert.ErrorContains(t, err, "host_options.Binds /home/viam/database is not under allowed_bind_prefixes")
+
+	conf = validRunConfig()
+	conf.ImageName = "quay.io/team/app"
+	_, err = conf.Validate("")
+	assert.ErrorContains(t, err, "image quay.io/team/app is from registry quay.io, which is not in allowed_registries")
+}
+
+func TestPolicyCompose(t *testing.T) {
+	writePolicy(t, `{"allow_host_namespaces": true, "allowed_bind_prefixes": ["/srv"]}`)
+
+	conf := &Config{
+		ImageName:  "ghcr.io/team/app",
+		RepoDigest: "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		ComposeOptions: &ComposeOptions{ComposeFile: []string{
+			"services:",
+			"  app:",
+			"    image: ghcr.io/team/app@sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+			"    privileged: true",
+			"    network_mode: host",
+			"    volumes:",
+			"      - /etc:/host-etc",
+			"      - /srv/app:/data",
+		}},
+	}
+	_, err := conf.Validate("")
+	assert.ErrorContains(t, err, "service app: privileged is not allowed")
+	assert.ErrorContains(t, err, "service app: bind mount /etc is not under allowed_bind_prefixes")
+	assert.NotContains(t, err.Error(), "network_mode")
+	assert.NotContains(t, err.Error(), "/srv/app")
+}
+
+func TestPolicyVolumeBinds(t *testing.T) {
+	writePolicy(t, `{"allowed_bind_prefixes": ["/srv"]}`)
+
+	conf := validRunConfig()
+	conf.RunOptions.Volumes = []VolumeOptions{
+		{Name: "root", Target: "/host", DriverOpts: map[string]string{"type": "none", "o": "bind", "device": "/"}},
+		{Name: "data", Target: "/data", DriverOpts: map[string]string{"type": "none", "o": "rbind,ro", "device": "/srv/data"}},
+		{Name: "nfs", Target: "/nfs", DriverOpts: map[string]string{"type": "nfs", "o": "addr=10.0.0.1,rw", "device": ":/exports"}},
+	}
+	_, err := conf.Validate("")
+	assert.ErrorContains(t, err, "volume root: bind device / is not under allowed_bind_prefixes")
+	assert.NotContains(t, err.Error(), "volume data")
+	assert.NotContains(t, err.Error(), "volume nfs")
+
+	compose := &Config{
+		ImageName:  "ghcr.io/team/app",
+		RepoDigest: "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		ComposeOptions: &ComposeOptions{ComposeFile: []string{
+			"services:",
+			"  app:",
+			"    image: ghcr.io/team/app@sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+			"    volumes:",
+			"      - etc:/host-etc",
+			"volumes:",
+			"  etc:",
+			"    driver_opts:",
+			"      type: none",
+			"      o: bind",
+			"      device: /etc",
+		}},
+	}
+	_, err = compose.Validate("")
+	assert.ErrorContains(t, err, "volume etc: bind device /etc is not under allowed_bind_prefixes")
+
+	// Volumes are checked again when they are created
+	err = enforcePolicy(func(policy *Policy) []error { return policy.checkVolumes(conf.RunOptions.Volumes[:1]) })
+	assert.ErrorIs(t, err, ErrPolicyViolation)
+}
+
+func TestPolicyMissingOrInvalid(t *testing.T) {
+	// Without a policy file everything is allowed
+	t.Setenv("VIAM_MODULE_DATA", t.TempDir())
+	conf := validRunConfig()
+	conf.RunOptions.HostOptions = map[string]interface{}{"Binds": "/:/host", "NetworkMode": "host", "AutoRemove": false}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	// A policy with a typo denies everything rather than nothing
+	writePolicy(t, `{"allowed_registry": ["docker.io"]}`)
+	_, err = validRunConfig().Validate("")
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+
+	err = enforcePolicy(func(policy *Policy) []error { return nil })
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+}
+
+func TestPolicySecretsDirectory(t *testing.T) {
+	moduleData := t.TempDir()
+	t.Setenv("VIAM_MODULE_DATA", moduleData)
+	writePolicy(t, `{"allowed_bind_prefixes": ["/srv"]}`)
+
+	conf := validRunConfig()
+	conf.SecretsDirectory = "/etc"
+	conf.RunOptions.EnvFromFiles = map[string]string{"SHADOW": "shadow"}
+	_, err := conf.Validate("")
+	assert.ErrorContains(t, err, "secrets_dir /etc is not in VIAM_MODULE_DATA or under allowed_bind_prefixes")
+	// Secrets are checked again when they are read
+	_, err = resolveSecrets(conf)
+	assert.ErrorIs(t, err, ErrPolicyViolation)
+
+	for _, dir := range []string{"/srv/secrets", filepath.Join(moduleData, "secrets")} {
+		conf.SecretsDirectory = dir
+		_, err = conf.Validate("")
+		assert.NoError(t, err, dir)
+	}
+}
+
+func TestPolicyBindSymlinks(t *testing.T) {
+	allowed := t.TempDir()
+	writePolicy(t, `{"allowed_bind_prefixes": ["`+allowed+`"]}`)
+	policy, err := loadPolicy()
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.Symlink("/etc", filepath.Join(allowed, "etc")))
+	assert.NoError(t, os.Mkdir(filepath.Join(allowed, "data"), 0700))
+	assert.False(t, policy.bindAllowed(filepath.Join(allowed, "etc")))
+	// Paths that don't exist yet are created where the link points
+	assert.False(t, policy.bindAllowed(filepath.Join(allowed, "etc", "new", "dir")))
+	assert.True(t, policy.bindAllowed(filepath.Join(allowed, "data")))
+	assert.True(t, policy.bindAllowed(filepath.Join(allowed, "new")))
+
+	conf := validRunConfig()
+	conf.RunOptions.HostOptions = map[string]interface{}{"Binds": filepath.Join(allowed, "etc") + ":/host-etc"}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrPolicyViolation)
+}
@@ -0,0 +1,162 @@
+package docker_deploy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/go-connections/nat"
+)
+
+var ErrInvalidPortSpec = errors.New("ports must look like [hostIP:][hostPort:]containerPort[/protocol]")
+var ErrPortConflict = errors.New("host port is already published")
+
+// hostPort is a port published on the host
+type hostPort struct {
+	ip    string
+	port  string
+	proto string
+}
+
+func (hp hostPort) String() string {
+	if hp.ip == "" {
+		return fmt.Sprintf("%s/%s", hp.port, hp.proto)
+	}
+	return fmt.Sprintf("%s:%s/%s", hp.ip, hp.port, hp.proto)
+}
+
+// overlaps reports whether two published ports can't both be bound, an empty or unspecified IP binds every address.
+// A host port range, as in 8000-8010:80, overlaps every port in the range.
+func (hp hostPort) overlaps(other hostPort) bool {
+	if hp.proto != other.proto {
+		return false
+	}
+	start, end, err := nat.ParsePortRangeToInt(hp.port)
+	otherStart, otherEnd, otherErr := nat.ParsePortRangeToInt(other.port)
+	if err != nil || otherErr != nil {
+		if hp.port != other.port {
+			return false
+		}
+	} else if start > otherEnd || otherStart > end {
+		return false
+	}
+	return isUnspecifiedIP(hp.ip) || isUnspecifiedIP(other.ip) || hp.ip == other.ip
+}
+
+func isUnspecifiedIP(ip string) bool {
+	return ip == "" || ip == "0.0.0.0" || ip == "::"
+}
+
+// parsePorts translates ports in Docker CLI syntax, e.g. 8080:80, 127.0.0.1:8080:80/udp or 7000-7005:7000-7005, into
+// the exposed ports of the container config and the port bindings of the host config
+func parsePorts(ports []string) (nat.PortSet, nat.PortMap, error) {
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+	for _, spec := range ports {
+		mappings, err := nat.ParsePortSpec(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %q: %v", ErrInvalidPortSpec, spec, err)
+		}
+		for _, mapping := range mappings {
+			exposed[mapping.Port] = struct{}{}
+			bindings[mapping.Port] = append(bindings[mapping.Port], mapping.Binding)
+		}
+	}
+	return exposed, bindings, nil
+}
+
+// publishedPorts returns the host ports of the config. Ports without a host port are published on a port the daemon
+// picks, they never conflict.
+func publishedPorts(conf *Config) ([]hostPort, error) {
+	if conf.RunOptions == nil || len(conf.RunOptions.Ports) == 0 {
+		return nil, nil
+	}
+	_, bindings, err := parsePorts(conf.RunOptions.Ports)
+	if err != nil {
+		return nil, err
+	}
+	var published []hostPort
+	for port, portBindings := range bindings {
+		for _, binding := range portBindings {
+			if binding.HostPort == "" {
+				continue
+			}
+			published = append(published, hostPort{ip: strings.Trim(binding.HostIP, "[]"), port: binding.HostPort, proto: port.Proto()})
+		}
+	}
+	return published, nil
+}
+
+// validatePorts checks the syntax of the ports of a config, and that it doesn't publish a host port twice
+func validatePorts(conf *Config) []error {
+	published, err := publishedPorts(conf)
+	if err != nil {
+		return []error{err}
+	}
+	var validationErrors []error
+	for i, port := range published {
+		for _, other := range published[:i] {
+			if port.overlaps(other) {
+				validationErrors = append(validationErrors, fmt.Errorf("ports %s and %s: %w", other, port, ErrPortConflict))
+			}
+		}
+	}
+	return validationErrors
+}
+
+// The host ports published by every component of the module
+var modulePorts = &portRegistry{byComponent: map[string][]hostPort{}}
+
+type portRegistry struct {
+	mu          sync.Mutex
+	byComponent map[string][]hostPort
+}
+
+// reserve records the host ports of a component, unless another component of the module already publishes one of them
+func (pr *portRegistry) reserve(component string, conf *Config) error {
+	published, err := publishedPorts(conf)
+	if err != nil {
+		return err
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	for other, ports := range pr.byComponent {
+		if other == component {
+			continue
+		}
+		for _, port := range published {
+			for _, otherPort := range ports {
+				if port.overlaps(otherPort) {
+					return fmt.Errorf("%s is published by %s: %w", port, other, ErrPortConflict)
+				}
+			}
+		}
+	}
+	pr.byComponent[component] = published
+	return nil
+}
+
+// ports returns the host ports reserved by a component
+func (pr *portRegistry) ports(component string) []hostPort {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.byComponent[component]
+}
+
+// set puts back ports returned by ports, without checking them against other components
+func (pr *portRegistry) set(component string, ports []hostPort) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if len(ports) == 0 {
+		delete(pr.byComponent, component)
+		return
+	}
+	pr.byComponent[component] = ports
+}
+
+func (pr *portRegistry) remove(component string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	delete(pr.byComponent, component)
+}
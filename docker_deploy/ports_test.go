@@ -0,0 +1,102 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/resource"
+)
+
+func TestParsePorts(t *testing.T) {
+	exposed, bindings, err := parsePorts([]string{"8080:80", "127.0.0.1:5353:53/udp", "7000-7001:9000-9001", "9090"})
+	assert.NoError(t, err)
+	assert.Equal(t, nat.PortSet{"80/tcp": {}, "53/udp": {}, "9000/tcp": {}, "9001/tcp": {}, "9090/tcp": {}}, exposed)
+	assert.Equal(t, nat.PortMap{
+		"80/tcp":   {{HostPort: "8080"}},
+		"53/udp":   {{HostIP: "127.0.0.1", HostPort: "5353"}},
+		"9000/tcp": {{HostPort: "7000"}},
+		"9001/tcp": {{HostPort: "7001"}},
+		"9090/tcp": {{}},
+	}, bindings)
+
+	_, _, err = parsePorts([]string{"8080:80/sctp2"})
+	assert.ErrorIs(t, err, ErrInvalidPortSpec)
+	_, _, err = parsePorts([]string{"7000-7002:9000-9001"})
+	assert.ErrorIs(t, err, ErrInvalidPortSpec)
+}
+
+func TestValidatePorts(t *testing.T) {
+	conf := validRunConfig()
+	conf.RunOptions.Ports = []string{"8080:80", "127.0.0.1:8081:81", "8081:81/udp", "80"}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	conf.RunOptions.Ports = []string{"8080:80", "127.0.0.1:8080:81"}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrPortConflict)
+
+	// Host port ranges are compared port by port
+	conf.RunOptions.Ports = []string{"8000-8010:80", "8005:81"}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrPortConflict)
+
+	conf.RunOptions.Ports = []string{"8000-8010:80", "8011:81"}
+	_, err = conf.Validate("")
+	assert.NoError(t, err)
+
+	conf.RunOptions.Ports = []string{"http"}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrInvalidPortSpec)
+}
+
+func TestPortConflictsBetweenComponents(t *testing.T) {
+	registry := &portRegistry{byComponent: map[string][]hostPort{}}
+	first := validRunConfig()
+	first.RunOptions.Ports = []string{"127.0.0.1:8080:80"}
+	second := validRunConfig()
+	second.RunOptions.Ports = []string{"127.0.0.2:8080:80", "8443:443"}
+
+	assert.NoError(t, registry.reserve("first", first))
+	assert.NoError(t, registry.reserve("second", second))
+	// A component can always reserve its own ports again
+	assert.NoError(t, registry.reserve("first", first))
+
+	third := validRunConfig()
+	third.RunOptions.Ports = []string{"8080:8080"}
+	err := registry.reserve("third", third)
+	assert.ErrorIs(t, err, ErrPortConflict)
+	assert.ErrorContains(t, err, "8080/tcp is published by")
+
+	registry.remove("first")
+	registry.remove("second")
+	assert.NoError(t, registry.reserve("third", third))
+}
+
+func TestReconfigureReleasesPortsOnError(t *testing.T) {
+	t.Setenv("VIAM_MODULE_DATA", t.TempDir())
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	reconfigure := func(conf *Config) error {
+		return dc.Reconfigure(context.Background(), nil, resource.Config{Name: "container0", API: sensor.API, ConvertedAttributes: conf})
+	}
+	conf := validRunConfig()
+	conf.RunOptions.Ports = []string{"8080:80"}
+	manager.images[conf.RepoDigest] = true
+	assert.NoError(t, reconfigure(conf))
+	reserved := modulePorts.ports(dc.Name().String())
+	assert.Len(t, reserved, 1)
+
+	// The secret file is missing, so the new ports are never published
+	broken := validRunConfig()
+	broken.RunOptions.Ports = []string{"9090:80"}
+	broken.RunOptions.EnvFromFiles = map[string]string{"TOKEN": "missing"}
+	assert.Error(t, reconfigure(broken))
+	assert.Equal(t, reserved, modulePorts.ports(dc.Name().String()))
+
+	assert.NoError(t, dc.Close(context.Background()))
+	assert.Error(t, reconfigure(broken))
+	assert.Empty(t, modulePorts.ports(dc.Name().String()))
+}
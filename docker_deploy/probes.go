@@ -0,0 +1,380 @@
+package docker_deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	viamutils "go.viam.com/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var ErrUnknownProbeType = errors.New("probe type must be http, tcp or grpc")
+var ErrProbePortRequired = errors.New("probes require a port between 1 and 65535")
+var ErrProbeDuplicate = errors.New("probe name is used more than once")
+var ErrProbeNegative = errors.New("probe durations and thresholds must not be negative")
+var ErrProbeServiceWithRunOptions = errors.New("probe service is only supported with compose_options")
+var ErrContainerNotRunning = errors.New("container is not running")
+
+const (
+	ProbeTypeHTTP = "http"
+	ProbeTypeTCP  = "tcp"
+	ProbeTypeGRPC = "grpc"
+)
+
+const defaultProbeInterval = 10 * time.Second
+const defaultProbeTimeout = 2 * time.Second
+
+// How many results of each probe are kept for readings
+const probeHistorySize = 20
+
+// HTTP probes only read this much of the body looking for expected_body
+const maxProbeBodySize = 64 * 1024
+
+// ProbeOptions describes a check the module runs against a container, for images that can't run a healthcheck
+// themselves
+type ProbeOptions struct {
+	Name                 string  `json:"name"`
+	Type                 string  `json:"type"`
+	Service              string  `json:"service"`
+	Port                 int     `json:"port"`
+	Path                 string  `json:"path"`
+	ExpectedStatus       int     `json:"expected_status"`
+	ExpectedBody         string  `json:"expected_body"`
+	GRPCService          string  `json:"grpc_service"`
+	IntervalSeconds      float64 `json:"interval_seconds"`
+	TimeoutSeconds       float64 `json:"timeout_seconds"`
+	InitialDelaySeconds  float64 `json:"initial_delay_seconds"`
+	RestartAfterFailures int     `json:"restart_after_failures"`
+}
+
+// name identifies the probe in readings, e.g. http:8080 unless the probe is named
+func (po *ProbeOptions) name() string {
+	if po.Name != "" {
+		return po.Name
+	}
+	if po.Service != "" {
+		return fmt.Sprintf("%s:%s:%d", po.Service, po.Type, po.Port)
+	}
+	return fmt.Sprintf("%s:%d", po.Type, po.Port)
+}
+
+func (po *ProbeOptions) interval() time.Duration {
+	if po.IntervalSeconds <= 0 {
+		return defaultProbeInterval
+	}
+	return seconds(po.IntervalSeconds)
+}
+
+func (po *ProbeOptions) timeout() time.Duration {
+	if po.TimeoutSeconds <= 0 {
+		return defaultProbeTimeout
+	}
+	return seconds(po.TimeoutSeconds)
+}
+
+func (po *ProbeOptions) validate() []error {
+	var validationErrors []error
+	wrap := func(err error) error {
+		return fmt.Errorf("probe %s: %w", po.name(), err)
+	}
+	switch po.Type {
+	case ProbeTypeHTTP, ProbeTypeTCP, ProbeTypeGRPC:
+	default:
+		validationErrors = append(validationErrors, wrap(fmt.Errorf("%w: %q", ErrUnknownProbeType, po.Type)))
+	}
+	if po.Port < 1 || po.Port > 65535 {
+		validationErrors = append(validationErrors, wrap(ErrProbePortRequired))
+	}
+	if po.IntervalSeconds < 0 || po.TimeoutSeconds < 0 || po.InitialDelaySeconds < 0 || po.RestartAfterFailures < 0 {
+		validationErrors = append(validationErrors, wrap(ErrProbeNegative))
+	}
+	return validationErrors
+}
+
+func validateProbes(conf *Config) []error {
+	var validationErrors []error
+	names := map[string]bool{}
+	for _, probe := range conf.Probes {
+		validationErrors = append(validationErrors, probe.validate()...)
+		if probe.Service != "" && conf.ComposeOptions == nil {
+			validationErrors = append(validationErrors, fmt.Errorf("probe %s: %w", probe.name(), ErrProbeServiceWithRunOptions))
+		}
+		if names[probe.name()] {
+			validationErrors = append(validationErrors, fmt.Errorf("%s: %w", probe.name(), ErrProbeDuplicate))
+		}
+		names[probe.name()] = true
+	}
+	return validationErrors
+}
+
+// probe runs the check once against the address
+func probe(ctx context.Context, po *ProbeOptions, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, po.timeout())
+	defer cancel()
+	switch po.Type {
+	case ProbeTypeHTTP:
+		return probeHTTP(ctx, po, address)
+	case ProbeTypeTCP:
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeTypeGRPC:
+		return probeGRPC(ctx, po, address)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownProbeType, po.Type)
+	}
+}
+
+// probeHTTP expects expected_status, or any 2xx or 3xx status, and expected_body somewhere in the body if it is set
+func probeHTTP(ctx context.Context, po *ProbeOptions, address string) error {
+	path := po.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if po.ExpectedStatus != 0 && resp.StatusCode != po.ExpectedStatus {
+		return fmt.Errorf("status %d, expected %d", resp.StatusCode, po.ExpectedStatus)
+	}
+	if po.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if po.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), po.ExpectedBody) {
+			return fmt.Errorf("body doesn't contain %q", po.ExpectedBody)
+		}
+	}
+	return nil
+}
+
+// probeGRPC uses the standard grpc.health.v1 health check
+func probeGRPC(ctx context.Context, po *ProbeOptions, address string) error {
+	conn, err := grpc.DialContext(ctx, address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: po.GRPCService})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+type probeResult struct {
+	at      time.Time
+	latency time.Duration
+	err     error
+}
+
+// probeState is the recent history of a probe
+type probeState struct {
+	mu                  sync.Mutex
+	options             ProbeOptions
+	history             []probeResult
+	consecutiveFailures int
+}
+
+// record adds a result to the history and returns the number of consecutive failures
+func (ps *probeState) record(result probeResult) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.history = append(ps.history, result)
+	if len(ps.history) > probeHistorySize {
+		ps.history = ps.history[len(ps.history)-probeHistorySize:]
+	}
+	if result.err == nil {
+		ps.consecutiveFailures = 0
+	} else {
+		ps.consecutiveFailures++
+	}
+	return ps.consecutiveFailures
+}
+
+func (ps *probeState) resetFailures() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.consecutiveFailures = 0
+}
+
+// passing reports whether the most recent check succeeded, a probe that hasn't run yet isn't passing
+func (ps *probeState) passing() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return len(ps.history) > 0 && ps.history[len(ps.history)-1].err == nil
+}
+
+func (ps *probeState) readings() map[string]interface{} {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	history := make([]interface{}, 0, len(ps.history))
+	for _, result := range ps.history {
+		history = append(history, map[string]interface{}{
+			"at":         result.at.UTC().Format(time.RFC3339),
+			"latency_ms": float64(result.latency.Microseconds()) / 1000,
+			"ok":         result.err == nil,
+		})
+	}
+	readings := map[string]interface{}{
+		"type":                 ps.options.Type,
+		"passing":              len(ps.history) > 0 && ps.history[len(ps.history)-1].err == nil,
+		"consecutive_failures": ps.consecutiveFailures,
+		"history":              history,
+	}
+	if len(ps.history) > 0 {
+		if err := ps.history[len(ps.history)-1].err; err != nil {
+			readings["last_error"] = err.Error()
+		}
+	}
+	return readings
+}
+
+// startProbes replaces the running probes with the probes of the config
+func (dc *DockerConfig) startProbes(probes []ProbeOptions) {
+	dc.stopProbes()
+	if len(probes) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(dc.cancelCtx)
+	states := make([]*probeState, 0, len(probes))
+	for _, options := range probes {
+		state := &probeState{options: options}
+		states = append(states, state)
+		viamutils.PanicCapturingGo(func() { dc.runProbe(ctx, state) })
+	}
+	dc.probeMu.Lock()
+	defer dc.probeMu.Unlock()
+	dc.probes = states
+	dc.probeCancelFunc = cancel
+}
+
+func (dc *DockerConfig) stopProbes() {
+	dc.probeMu.Lock()
+	defer dc.probeMu.Unlock()
+	if dc.probeCancelFunc != nil {
+		dc.probeCancelFunc()
+	}
+	dc.probes = nil
+	dc.probeCancelFunc = nil
+}
+
+func (dc *DockerConfig) getProbes() []*probeState {
+	dc.probeMu.Lock()
+	defer dc.probeMu.Unlock()
+	return dc.probes
+}
+
+// runProbe checks the container on the probe's interval until ctx is cancelled
+func (dc *DockerConfig) runProbe(ctx context.Context, state *probeState) {
+	delay := seconds(state.options.InitialDelaySeconds)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		dc.checkProbe(ctx, state)
+		delay = state.options.interval()
+	}
+}
+
+// checkProbe runs the probe once, and restarts the container once it has failed restart_after_failures times in a row
+func (dc *DockerConfig) checkProbe(ctx context.Context, state *probeState) {
+	// Containers are paused or stopped on purpose during a backup or restore
+	if dc.isQuiesced() {
+		return
+	}
+	target := dc.probeTarget(&state.options)
+	if target == "" {
+		return
+	}
+
+	start := time.Now()
+	address, err := dc.manager.ContainerAddress(ctx, target, state.options.Port)
+	if err == nil {
+		err = probe(ctx, &state.options, address)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	failures := state.record(probeResult{at: start, latency: time.Since(start), err: err})
+	if err != nil {
+		dc.logger.Debugf("Probe %s of %s failed: %v", state.options.name(), target, err)
+	}
+
+	// Stopped containers are started by their supervisors, not restarted here
+	if state.options.RestartAfterFailures == 0 || failures < state.options.RestartAfterFailures || errors.Is(err, ErrContainerNotRunning) {
+		return
+	}
+	dc.logger.Warnf("Probe %s of %s failed %d times in a row, restarting the container: %v", state.options.name(), target, failures, err)
+	conf := dc.stopConfig()
+	if err := dc.stopContainer(&conf, target); err != nil {
+		dc.logger.Errorf("Unable to stop container %s: %v", target, err)
+	}
+	if err := dc.manager.StartContainer(target); err != nil {
+		dc.logger.Errorf("Unable to start container %s: %v", target, err)
+	}
+	state.resetFailures()
+}
+
+// probeTarget returns the container the probe checks, the compose service's container or the component's container
+func (dc *DockerConfig) probeTarget(po *ProbeOptions) string {
+	if po.Service != "" {
+		// Compose containers are named after their service
+		return po.Service
+	}
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	if len(dc.containers) == 0 {
+		return ""
+	}
+	return dc.containers[0].GetContainerId()
+}
+
+// probesPassing reports whether every probe passed its most recent check
+func (dc *DockerConfig) probesPassing() bool {
+	for _, state := range dc.getProbes() {
+		if !state.passing() {
+			return false
+		}
+	}
+	return true
+}
+
+func (dc *DockerConfig) probeReadings() map[string]interface{} {
+	probes := dc.getProbes()
+	if len(probes) == 0 {
+		return nil
+	}
+	readings := make(map[string]interface{}, len(probes))
+	for _, state := range probes {
+		readings[state.options.name()] = state.readings()
+	}
+	return readings
+}
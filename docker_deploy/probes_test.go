@@ -0,0 +1,132 @@
+package docker_deploy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestValidateProbes(t *testing.T) {
+	conf := validRunConfig()
+	conf.Probes = []ProbeOptions{
+		{Type: "http", Port: 8080, Path: "/healthz", ExpectedStatus: 204, RestartAfterFailures: 3},
+		{Type: "tcp", Port: 5432},
+		{Name: "api", Type: "grpc", Port: 50051, GRPCService: "viam.Api"},
+	}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	conf.Probes = []ProbeOptions{
+		{Type: "udp", Port: 53},
+		{Type: "tcp"},
+		{Type: "tcp", Port: 5432, IntervalSeconds: -1},
+		{Type: "tcp", Port: 5432},
+		{Type: "http", Port: 80, Service: "web"},
+	}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrUnknownProbeType)
+	assert.ErrorIs(t, err, ErrProbePortRequired)
+	assert.ErrorIs(t, err, ErrProbeNegative)
+	assert.ErrorIs(t, err, ErrProbeDuplicate)
+	assert.ErrorIs(t, err, ErrProbeServiceWithRunOptions)
+}
+
+func TestProbeHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"status": "ok"}`)
+	}))
+	defer server.Close()
+	address := server.Listener.Addr().String()
+
+	assert.NoError(t, probe(context.Background(), &ProbeOptions{Type: "http", Path: "healthz"}, address))
+	assert.NoError(t, probe(context.Background(), &ProbeOptions{Type: "http", Path: "/healthz", ExpectedStatus: 200, ExpectedBody: `"ok"`}, address))
+	assert.ErrorContains(t, probe(context.Background(), &ProbeOptions{Type: "http", Path: "/healthz", ExpectedBody: "ready"}, address), `body doesn't contain "ready"`)
+	assert.ErrorContains(t, probe(context.Background(), &ProbeOptions{Type: "http", Path: "/"}, address), "status 404")
+	assert.ErrorContains(t, probe(context.Background(), &ProbeOptions{Type: "http", Path: "/healthz", ExpectedStatus: 204}, address), "status 200, expected 204")
+}
+
+func TestProbeTCPAndGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	defer server.Stop()
+	address := listener.Addr().String()
+
+	assert.NoError(t, probe(context.Background(), &ProbeOptions{Type: "tcp"}, address))
+	assert.NoError(t, probe(context.Background(), &ProbeOptions{Type: "grpc"}, address))
+
+	healthServer.SetServingStatus("viam.Api", healthpb.HealthCheckResponse_NOT_SERVING)
+	assert.ErrorContains(t, probe(context.Background(), &ProbeOptions{Type: "grpc", GRPCService: "viam.Api"}, address), "NOT_SERVING")
+
+	server.Stop()
+	assert.Error(t, probe(context.Background(), &ProbeOptions{Type: "tcp", TimeoutSeconds: 0.5}, address))
+}
+
+func TestProbeFailuresRestartContainer(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	manager := newFakeDockerManager()
+	manager.addresses = map[string]string{"c1": server.Listener.Addr().String()}
+	dc := newTestDockerConfig(t, manager)
+	dc.containers = []DockerContainer{&fakeDockerContainer{id: "c1"}}
+	state := &probeState{options: ProbeOptions{Type: "http", Port: 8080, RestartAfterFailures: 2}}
+	dc.probes = []*probeState{state}
+
+	// Probes that haven't run yet aren't passing
+	ready, err := dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	dc.checkProbe(context.Background(), state)
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	healthy = false
+	dc.checkProbe(context.Background(), state)
+	ready, err = dc.Ready(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+	assert.Empty(t, manager.containerEvents)
+
+	dc.checkProbe(context.Background(), state)
+	assert.Equal(t, []string{"stop c1", "start c1"}, manager.containerEvents)
+
+	readings, err := dc.Readings(context.Background(), nil)
+	assert.NoError(t, err)
+	probes := readings["probes"].(map[string]interface{})
+	assert.Contains(t, probes, "http:8080")
+	probeReadings := probes["http:8080"].(map[string]interface{})
+	assert.Equal(t, false, probeReadings["passing"])
+	assert.Equal(t, 0, probeReadings["consecutive_failures"])
+	assert.Equal(t, "status 503", probeReadings["last_error"])
+	assert.Len(t, probeReadings["history"], 3)
+
+	// A container that isn't running is left to its supervisor
+	delete(manager.addresses, "c1")
+	manager.containerEvents = nil
+	dc.checkProbe(context.Background(), state)
+	dc.checkProbe(context.Background(), state)
+	assert.Empty(t, manager.containerEvents)
+}
@@ -0,0 +1,192 @@
+package docker_deploy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	viamutils "go.viam.com/utils"
+)
+
+// Module env variable that caps how many pulls run at once across every component of the module
+const maxConcurrentPullsEnv = "VIAM_DOCKER_MAX_CONCURRENT_PULLS"
+const defaultMaxConcurrentPulls = 2
+
+// The coordinator shared by every component in the module
+var modulePullCoordinator = newPullCoordinator(maxConcurrentPullsFromEnv())
+
+func maxConcurrentPullsFromEnv() int {
+	if v, err := strconv.Atoi(os.Getenv(maxConcurrentPullsEnv)); err == nil && v > 0 {
+		return v
+	}
+	return defaultMaxConcurrentPulls
+}
+
+// pullCoordinator dedupes pulls of the same digest and limits how many pulls run at once. Pulls waiting for a slot
+// are started in order of priority, highest first, then in the order they were requested.
+type pullCoordinator struct {
+	mu            sync.Mutex
+	maxConcurrent int
+	running       int
+	queue         []*pullSlotRequest
+	inflight      map[string]*pullCall
+	sequence      uint64
+}
+
+// pullKey identifies the pulls that can be shared: the same digest, pulled into the same runtime with the same
+// credentials. A component joining a pull started with other credentials would get that pull's result, including an
+// authentication error its own credentials wouldn't have had.
+func pullKey(image PinnedImage, conf *Config) string {
+	encoded, err := NewRegistryAuth(conf.Credentials, conf.DockerConfigPath).EncodedAuthFor(image.ImageName)
+	if err != nil {
+		encoded = "error: " + err.Error()
+	}
+	sum := sha256.Sum256([]byte(encoded))
+	return fmt.Sprintf("%s/%s/%x", conf.runtime(), image.RepoDigest, sum[:8])
+}
+
+type pullSlotRequest struct {
+	priority int
+	sequence uint64
+	ready    chan struct{}
+}
+
+// pullCall is a single pull shared by everyone who asked for the same digest while it was running
+type pullCall struct {
+	done     chan struct{}
+	err      error
+	progress *PullProgress
+	cancel   func()
+	waiters  int
+	// priority is the highest priority of the callers, request is set while the pull waits for a slot
+	priority int
+	request  *pullSlotRequest
+}
+
+func newPullCoordinator(maxConcurrent int) *pullCoordinator {
+	return &pullCoordinator{maxConcurrent: maxConcurrent, inflight: map[string]*pullCall{}}
+}
+
+// pull runs fn to pull the image identified by key, or waits for the same pull if another component already
+// started it. onProgress is called with the progress of the pull that is actually used. The pull is only cancelled
+// once every caller waiting on it has given up.
+func (pc *pullCoordinator) pull(ctx context.Context, key string, priority int, progress *PullProgress, onProgress func(*PullProgress), fn func(context.Context, *PullProgress) error) error {
+	pc.mu.Lock()
+	call, ok := pc.inflight[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.Background())
+		call = &pullCall{done: make(chan struct{}), progress: progress, cancel: cancel, priority: priority}
+		pc.inflight[key] = call
+		viamutils.PanicCapturingGo(func() {
+			err := pc.acquire(callCtx, call)
+			if err == nil {
+				err = fn(callCtx, call.progress)
+				pc.release()
+			}
+			call.progress.finish(err)
+
+			pc.mu.Lock()
+			if pc.inflight[key] == call {
+				delete(pc.inflight, key)
+			}
+			pc.mu.Unlock()
+			call.err = err
+			cancel()
+			close(call.done)
+		})
+	}
+	call.waiters++
+	// A queued pull starts as soon as the most important of its callers would have
+	if priority > call.priority {
+		call.priority = priority
+		if call.request != nil {
+			call.request.priority = priority
+		}
+	}
+	pc.mu.Unlock()
+
+	if onProgress != nil {
+		onProgress(call.progress)
+	}
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		pc.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody wants this pull anymore, anyone asking for the digest from now on starts a new one
+			if pc.inflight[key] == call {
+				delete(pc.inflight, key)
+			}
+			call.cancel()
+		}
+		pc.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// acquire waits for one of the maxConcurrent pull slots for the call
+func (pc *pullCoordinator) acquire(ctx context.Context, call *pullCall) error {
+	pc.mu.Lock()
+	if pc.running < pc.maxConcurrent && len(pc.queue) == 0 {
+		pc.running++
+		pc.mu.Unlock()
+		return nil
+	}
+	pc.sequence++
+	request := &pullSlotRequest{priority: call.priority, sequence: pc.sequence, ready: make(chan struct{})}
+	pc.queue = append(pc.queue, request)
+	call.request = request
+	pc.mu.Unlock()
+
+	select {
+	case <-request.ready:
+		pc.mu.Lock()
+		call.request = nil
+		pc.mu.Unlock()
+		return nil
+	case <-ctx.Done():
+		pc.mu.Lock()
+		defer pc.mu.Unlock()
+		call.request = nil
+		for i, r := range pc.queue {
+			if r == request {
+				pc.queue = append(pc.queue[:i], pc.queue[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The slot was handed to us while we were being cancelled, pass it on
+		pc.running--
+		pc.next()
+		return ctx.Err()
+	}
+}
+
+func (pc *pullCoordinator) release() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.running--
+	pc.next()
+}
+
+// next hands free slots to the highest priority requests in the queue, pc.mu must be held
+func (pc *pullCoordinator) next() {
+	for pc.running < pc.maxConcurrent && len(pc.queue) > 0 {
+		best := 0
+		for i, r := range pc.queue {
+			if r.priority > pc.queue[best].priority ||
+				(r.priority == pc.queue[best].priority && r.sequence < pc.queue[best].sequence) {
+				best = i
+			}
+		}
+		request := pc.queue[best]
+		pc.queue = append(pc.queue[:best], pc.queue[best+1:]...)
+		pc.running++
+		close(request.ready)
+	}
+}
@@ -0,0 +1,159 @@
+package docker_deploy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.viam.com/rdk/logging"
+)
+
+func TestPullCoordinatorDedupesDigests(t *testing.T) {
+	pc := newPullCoordinator(2)
+	logger := logging.NewTestLogger(t)
+	release := make(chan struct{})
+	var pulls atomic.Int32
+
+	var wg sync.WaitGroup
+	progresses := make([]*PullProgress, 2)
+	for i := 0; i < 2; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := pc.pull(context.Background(), "sha256:abc", 0, NewPullProgress("ubuntu", logger), func(p *PullProgress) { progresses[i] = p }, func(ctx context.Context, _ *PullProgress) error {
+				pulls.Add(1)
+				<-release
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	// Give both callers time to join the same pull
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), pulls.Load())
+	assert.Same(t, progresses[0], progresses[1])
+}
+
+func TestPullCoordinatorLimitsConcurrencyByPriority(t *testing.T) {
+	pc := newPullCoordinator(1)
+	logger := logging.NewTestLogger(t)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	var running, maxRunning int
+
+	pull := func(key string, priority int, wg *sync.WaitGroup) {
+		defer wg.Done()
+		err := pc.pull(context.Background(), key, priority, NewPullProgress(key, logger), nil, func(ctx context.Context, _ *PullProgress) error {
+			mu.Lock()
+			order = append(order, key)
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+			<-release
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go pull("first", 0, &wg)
+	time.Sleep(50 * time.Millisecond)
+	go pull("low", 0, &wg)
+	time.Sleep(50 * time.Millisecond)
+	go pull("high", 10, &wg)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, maxRunning)
+	assert.Equal(t, []string{"first", "high", "low"}, order)
+}
+
+func TestPullCoordinatorRaisesQueuedPriority(t *testing.T) {
+	pc := newPullCoordinator(1)
+	logger := logging.NewTestLogger(t)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+
+	pull := func(key string, priority int, wg *sync.WaitGroup) {
+		defer wg.Done()
+		err := pc.pull(context.Background(), key, priority, NewPullProgress(key, logger), nil, func(ctx context.Context, _ *PullProgress) error {
+			mu.Lock()
+			order = append(order, key)
+			mu.Unlock()
+			<-release
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go pull("first", 0, &wg)
+	time.Sleep(50 * time.Millisecond)
+	go pull("shared", 0, &wg)
+	time.Sleep(50 * time.Millisecond)
+	go pull("other", 5, &wg)
+	time.Sleep(50 * time.Millisecond)
+	// Joining the queued pull with a higher priority moves it ahead of other
+	go pull("shared", 10, &wg)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, []string{"first", "shared", "other"}, order)
+}
+
+func TestPullKeySeparatesCredentials(t *testing.T) {
+	image := PinnedImage{ImageName: "ghcr.io/viam-soleng/app", RepoDigest: "sha256:abc"}
+	anonymous := &Config{DockerConfigPath: writeDockerConfig(t, `{}`)}
+	valid := &Config{DockerConfigPath: anonymous.DockerConfigPath, Credentials: map[string]*Credentials{"ghcr.io": {Username: "user", Password: "pass"}}}
+	wrong := &Config{DockerConfigPath: anonymous.DockerConfigPath, Credentials: map[string]*Credentials{"ghcr.io": {Username: "user", Password: "wrong"}}}
+
+	assert.Equal(t, pullKey(image, valid), pullKey(image, valid))
+	assert.NotEqual(t, pullKey(image, anonymous), pullKey(image, valid))
+	assert.NotEqual(t, pullKey(image, wrong), pullKey(image, valid))
+	// Credentials for other registries don't matter
+	other := &Config{DockerConfigPath: anonymous.DockerConfigPath, Credentials: map[string]*Credentials{"quay.io": {Username: "user", Password: "pass"}}}
+	assert.Equal(t, pullKey(image, anonymous), pullKey(image, other))
+
+	podman := *valid
+	podman.Runtime = RuntimePodman
+	assert.NotEqual(t, pullKey(image, &podman), pullKey(image, valid))
+}
+
+func TestPullCoordinatorCancelsAbandonedPulls(t *testing.T) {
+	pc := newPullCoordinator(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan struct{})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	err := pc.pull(ctx, "sha256:abc", 0, nil, nil, func(ctx context.Context, _ *PullProgress) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the pull was not cancelled once nobody was waiting for it")
+	}
+}
@@ -0,0 +1,223 @@
+package docker_deploy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	PullStateQueued   = "queued"
+	PullStatePulling  = "pulling"
+	PullStateComplete = "complete"
+	PullStateFailed   = "failed"
+)
+
+// Progress is logged at info level every time another pullMilestonePercent of the image has downloaded
+const pullMilestonePercent = 10
+
+// pullMessage is a single message of the JSON stream returned by ImagePull
+type pullMessage struct {
+	Status         string `json:"status"`
+	ID             string `json:"id"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// The statuses the daemon reports for a layer, other messages with an id such as "Pulling from" are about the image
+var layerStatuses = map[string]bool{
+	"Pulling fs layer":   true,
+	"Waiting":            true,
+	"Downloading":        true,
+	"Verifying Checksum": true,
+	"Download complete":  true,
+	"Extracting":         true,
+	"Pull complete":      true,
+	"Already exists":     true,
+}
+
+type layerProgress struct {
+	current int64
+	total   int64
+	done    bool
+}
+
+// PullProgress aggregates the per layer progress of an image pull into totals for the whole image
+type PullProgress struct {
+	mu            sync.Mutex
+	logger        logging.Logger
+	image         string
+	state         string
+	err           error
+	layers        map[string]*layerProgress
+	startedAt     time.Time
+	finishedAt    time.Time
+	lastMilestone int
+	attempt       int
+	maxAttempts   int
+}
+
+func NewPullProgress(image string, logger logging.Logger) *PullProgress {
+	return &PullProgress{
+		logger:    logger,
+		image:     image,
+		state:     PullStateQueued,
+		layers:    map[string]*layerProgress{},
+		startedAt: time.Now(),
+	}
+}
+
+// update applies a message from the pull stream, returning the error reported by the daemon if there is one
+func (p *PullProgress) update(message *pullMessage) error {
+	if message.ErrorDetail != nil {
+		return errors.New(message.ErrorDetail.Message)
+	}
+	if p == nil || message.ID == "" {
+		return nil
+	}
+	if !layerStatuses[message.Status] && message.ProgressDetail.Current == 0 && message.ProgressDetail.Total == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	layer, ok := p.layers[message.ID]
+	if !ok {
+		layer = &layerProgress{}
+		p.layers[message.ID] = layer
+	}
+
+	switch message.Status {
+	case "Downloading":
+		layer.current = message.ProgressDetail.Current
+		if message.ProgressDetail.Total > 0 {
+			layer.total = message.ProgressDetail.Total
+		}
+	case "Verifying Checksum", "Download complete", "Extracting", "Pull complete", "Already exists":
+		layer.current = layer.total
+		layer.done = true
+	}
+
+	p.logMilestone()
+	return nil
+}
+
+// logMilestone logs at info level when the download crosses the next milestone, p.mu must be held
+func (p *PullProgress) logMilestone() {
+	downloaded, total := p.bytes()
+	if total == 0 {
+		return
+	}
+	percent := int(downloaded * 100 / total)
+	milestone := percent - percent%pullMilestonePercent
+	if milestone <= p.lastMilestone {
+		return
+	}
+	p.lastMilestone = milestone
+	p.logger.Infof("Pulling %s: %d%% (%d/%d bytes), eta %s", p.image, percent, downloaded, total, p.eta(downloaded, total).Round(time.Second))
+}
+
+// bytes returns the bytes downloaded and the total bytes of the layers seen so far, p.mu must be held
+func (p *PullProgress) bytes() (int64, int64) {
+	var downloaded, total int64
+	for _, layer := range p.layers {
+		downloaded += layer.current
+		total += layer.total
+	}
+	return downloaded, total
+}
+
+// eta estimates the remaining time from the average download rate so far, p.mu must be held
+func (p *PullProgress) eta(downloaded int64, total int64) time.Duration {
+	elapsed := time.Since(p.startedAt)
+	if downloaded == 0 || elapsed <= 0 {
+		return 0
+	}
+	rate := float64(downloaded) / elapsed.Seconds()
+	return time.Duration(float64(total-downloaded) / rate * float64(time.Second))
+}
+
+// start marks the pull as started once it is no longer waiting for a free pull slot
+func (p *PullProgress) start() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = PullStatePulling
+	p.startedAt = time.Now()
+}
+
+func (p *PullProgress) setAttempt(attempt int, maxAttempts int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempt = attempt
+	p.maxAttempts = maxAttempts
+}
+
+func (p *PullProgress) finish(err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finishedAt = time.Now()
+	p.err = err
+	if err != nil {
+		p.state = PullStateFailed
+		return
+	}
+	p.state = PullStateComplete
+	p.logger.Infof("Pulled %s in %s", p.image, p.finishedAt.Sub(p.startedAt).Round(time.Second))
+}
+
+// Status returns the progress in a form that can be returned from Readings and DoCommand
+func (p *PullProgress) Status() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	downloaded, total := p.bytes()
+	layersDone := 0
+	for _, layer := range p.layers {
+		if layer.done {
+			layersDone++
+		}
+	}
+	status := map[string]interface{}{
+		"image":            p.image,
+		"state":            p.state,
+		"bytes_downloaded": downloaded,
+		"bytes_total":      total,
+		"layers":           len(p.layers),
+		"layers_done":      layersDone,
+		"started_at":       p.startedAt.UTC().Format(time.RFC3339),
+	}
+	if p.maxAttempts > 0 {
+		status["attempt"] = p.attempt
+		status["max_attempts"] = p.maxAttempts
+	}
+	switch p.state {
+	case PullStatePulling:
+		percent := 0.0
+		if total > 0 {
+			percent = float64(downloaded) * 100 / float64(total)
+		}
+		status["percent"] = percent
+		status["eta_seconds"] = p.eta(downloaded, total).Seconds()
+	case PullStateComplete:
+		status["percent"] = 100.0
+		status["finished_at"] = p.finishedAt.UTC().Format(time.RFC3339)
+	case PullStateFailed:
+		status["error"] = p.err.Error()
+		status["finished_at"] = p.finishedAt.UTC().Format(time.RFC3339)
+	}
+	return status
+}
@@ -0,0 +1,62 @@
+package docker_deploy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.viam.com/rdk/logging"
+)
+
+func decodePullMessage(t *testing.T, raw string) *pullMessage {
+	var message pullMessage
+	assert.NoError(t, json.Unmarshal([]byte(raw), &message))
+	return &message
+}
+
+func TestPullProgressAggregatesLayers(t *testing.T) {
+	progress := NewPullProgress("ubuntu@sha256:abc", logging.NewTestLogger(t))
+	assert.Equal(t, PullStateQueued, progress.Status()["state"])
+	progress.start()
+	for _, raw := range []string{
+		`{"status":"Pulling from library/ubuntu","id":"sha256"}`,
+		`{"status":"Pulling fs layer","id":"a"}`,
+		`{"status":"Pulling fs layer","id":"b"}`,
+		`{"status":"Downloading","progressDetail":{"current":50,"total":100},"id":"a"}`,
+		`{"status":"Downloading","progressDetail":{"current":100,"total":300},"id":"b"}`,
+	} {
+		assert.NoError(t, progress.update(decodePullMessage(t, raw)))
+	}
+
+	status := progress.Status()
+	assert.Equal(t, PullStatePulling, status["state"])
+	assert.Equal(t, int64(150), status["bytes_downloaded"])
+	assert.Equal(t, int64(400), status["bytes_total"])
+	assert.InDelta(t, 37.5, status["percent"], 0.01)
+	assert.Equal(t, 2, status["layers"])
+	assert.Equal(t, 0, status["layers_done"])
+
+	assert.NoError(t, progress.update(decodePullMessage(t, `{"status":"Download complete","id":"a"}`)))
+	assert.NoError(t, progress.update(decodePullMessage(t, `{"status":"Pull complete","id":"b"}`)))
+	progress.finish(nil)
+
+	status = progress.Status()
+	assert.Equal(t, PullStateComplete, status["state"])
+	assert.Equal(t, int64(400), status["bytes_downloaded"])
+	assert.Equal(t, 100.0, status["percent"])
+}
+
+func TestPullProgressReportsErrors(t *testing.T) {
+	progress := NewPullProgress("ubuntu@sha256:abc", logging.NewTestLogger(t))
+	err := progress.update(decodePullMessage(t, `{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`))
+	assert.EqualError(t, err, "manifest unknown")
+
+	progress.finish(err)
+	status := progress.Status()
+	assert.Equal(t, PullStateFailed, status["state"])
+	assert.Equal(t, "manifest unknown", status["error"])
+
+	// A nil progress still reports the error
+	var nilProgress *PullProgress
+	assert.Error(t, nilProgress.update(decodePullMessage(t, `{"errorDetail":{"message":"denied"}}`)))
+}
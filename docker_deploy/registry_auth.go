@@ -0,0 +1,240 @@
+package docker_deploy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+	"go.viam.com/rdk/logging"
+)
+
+// The key Docker uses for Docker Hub in config.json and when talking to credential helpers
+const dockerHubServerAddress = "https://index.docker.io/v1/"
+
+var ErrCredentialsNotFound = errors.New("credentials not found")
+
+// RegistryAuth resolves the credentials used when pulling an image. Credentials from the component config for the
+// image's registry host take precedence, then those from the Docker config.json (credHelpers, credsStore and auths,
+// in the same order as the docker CLI).
+type RegistryAuth struct {
+	credentials      map[string]*Credentials
+	dockerConfigPath string
+	// logger is set by the manager using the credentials, problems that don't prevent a pull are logged to it
+	logger logging.Logger
+}
+
+// NewRegistryAuth creates a RegistryAuth from credentials keyed by registry host. An empty dockerConfigPath uses
+// $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+func NewRegistryAuth(credentials map[string]*Credentials, dockerConfigPath string) *RegistryAuth {
+	return &RegistryAuth{credentials: credentials, dockerConfigPath: dockerConfigPath}
+}
+
+// The subset of ~/.docker/config.json that is used for registry authentication
+type dockerConfigFile struct {
+	Auths       map[string]dockerAuthEntry `json:"auths"`
+	CredHelpers map[string]string          `json:"credHelpers"`
+	CredsStore  string                     `json:"credsStore"`
+}
+
+type dockerAuthEntry struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// EncodedAuthFor returns the RegistryAuth payload for a pull of imageName, or an empty string for an anonymous pull.
+func (ra *RegistryAuth) EncodedAuthFor(imageName string) (string, error) {
+	authConfig, err := ra.AuthConfigFor(imageName)
+	if err != nil || authConfig == nil {
+		return "", err
+	}
+	return registry.EncodeAuthConfig(*authConfig)
+}
+
+// AuthConfigFor returns the credentials for the registry imageName is hosted on, or nil if there are none.
+func (ra *RegistryAuth) AuthConfigFor(imageName string) (*registry.AuthConfig, error) {
+	host, err := registryHost(imageName)
+	if err != nil {
+		return nil, err
+	}
+	serverAddress := registryServerAddress(host)
+
+	if creds := ra.configuredCredentials(host); creds != nil {
+		return &registry.AuthConfig{
+			Username:      creds.Username,
+			Password:      creds.Password,
+			ServerAddress: serverAddress,
+		}, nil
+	}
+
+	dockerConfig, err := ra.loadDockerConfig()
+	if err != nil || dockerConfig == nil {
+		return nil, err
+	}
+
+	if helper, ok := dockerConfig.CredHelpers[host]; ok {
+		return credentialHelperAuth(helper, serverAddress)
+	}
+	if dockerConfig.CredsStore != "" {
+		// The credsStore applies to every registry, a broken or missing helper must not keep anonymous pulls from
+		// public registries working
+		authConfig, err := credentialHelperAuth(dockerConfig.CredsStore, serverAddress)
+		if err == nil {
+			return authConfig, nil
+		}
+		if !errors.Is(err, ErrCredentialsNotFound) && ra != nil && ra.logger != nil {
+			ra.logger.Warnf("Unable to get credentials for %s from credsStore %s, trying auths: %v", host, dockerConfig.CredsStore, err)
+		}
+	}
+	for key, entry := range dockerConfig.Auths {
+		if normalizeRegistryHost(key) == host {
+			return entry.authConfig(serverAddress)
+		}
+	}
+	return nil, nil
+}
+
+// configuredCredentials returns the credentials from the component config for a registry host
+func (ra *RegistryAuth) configuredCredentials(host string) *Credentials {
+	if ra == nil {
+		return nil
+	}
+	for key, creds := range ra.credentials {
+		if normalizeRegistryHost(key) == host {
+			return creds
+		}
+	}
+	return nil
+}
+
+func (ra *RegistryAuth) loadDockerConfig() (*dockerConfigFile, error) {
+	path := ""
+	if ra != nil {
+		path = ra.dockerConfigPath
+	}
+	if path == "" {
+		path = defaultDockerConfigPath()
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var dockerConfig dockerConfigFile
+	if err := json.Unmarshal(b, &dockerConfig); err != nil {
+		return nil, fmt.Errorf("unable to parse docker config %s: %w", path, err)
+	}
+	return &dockerConfig, nil
+}
+
+func defaultDockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+func (entry dockerAuthEntry) authConfig(serverAddress string) (*registry.AuthConfig, error) {
+	authConfig := &registry.AuthConfig{
+		Username:      entry.Username,
+		Password:      entry.Password,
+		IdentityToken: entry.IdentityToken,
+		RegistryToken: entry.RegistryToken,
+		ServerAddress: serverAddress,
+	}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode auth for %s: %w", serverAddress, err)
+		}
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid auth for %s", serverAddress)
+		}
+		authConfig.Username = username
+		authConfig.Password = password
+	}
+	return authConfig, nil
+}
+
+// credentialHelperAuth runs `docker-credential-<helper> get`, see https://github.com/docker/docker-credential-helpers
+func credentialHelperAuth(helper string, serverAddress string) (*registry.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(output, "credentials not found") {
+			return nil, ErrCredentialsNotFound
+		}
+		// Don't include the output, helpers may echo what they were given
+		return nil, fmt.Errorf("credential helper %s failed: %w", helper, err)
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, fmt.Errorf("unable to parse output of credential helper %s: %w", helper, err)
+	}
+
+	authConfig := &registry.AuthConfig{ServerAddress: serverAddress}
+	// Helpers return identity tokens with a username of <token>
+	if creds.Username == "<token>" {
+		authConfig.IdentityToken = creds.Secret
+	} else {
+		authConfig.Username = creds.Username
+		authConfig.Password = creds.Secret
+	}
+	return authConfig, nil
+}
+
+// registryHost returns the registry host of an image, e.g. ghcr.io for ghcr.io/org/image and docker.io for ubuntu
+func registryHost(imageName string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", err
+	}
+	return reference.Domain(named), nil
+}
+
+// normalizeRegistryHost turns a config.json key such as https://index.docker.io/v1/ into a registry host
+func normalizeRegistryHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return host
+}
+
+func registryServerAddress(host string) string {
+	if host == "docker.io" {
+		return dockerHubServerAddress
+	}
+	return host
+}
@@ -0,0 +1,128 @@
+package docker_deploy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/stretchr/testify/assert"
+	"go.viam.com/rdk/logging"
+)
+
+func writeDockerConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestRegistryHost(t *testing.T) {
+	for imageName, expected := range map[string]string{
+		"ubuntu":                      "docker.io",
+		"viam/app":                    "docker.io",
+		"ghcr.io/viam-soleng/app:1.0": "ghcr.io",
+		"harbor.local:8443/team/app":  "harbor.local:8443",
+		"localhost:5000/app:latest":   "localhost:5000",
+	} {
+		host, err := registryHost(imageName)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, host, imageName)
+	}
+}
+
+func TestRegistryAuthFromDockerConfigAuths(t *testing.T) {
+	path := writeDockerConfig(t, `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("hub:hubpass"))+`"},
+			"ghcr.io": {"username": "gh", "password": "ghpass"}
+		}
+	}`)
+	auth := NewRegistryAuth(nil, path)
+
+	authConfig, err := auth.AuthConfigFor("ubuntu")
+	assert.NoError(t, err)
+	assert.Equal(t, "hub", authConfig.Username)
+	assert.Equal(t, "hubpass", authConfig.Password)
+	assert.Equal(t, dockerHubServerAddress, authConfig.ServerAddress)
+
+	authConfig, err = auth.AuthConfigFor("ghcr.io/viam-soleng/app")
+	assert.NoError(t, err)
+	assert.Equal(t, "gh", authConfig.Username)
+
+	authConfig, err = auth.AuthConfigFor("quay.io/other/app")
+	assert.NoError(t, err)
+	assert.Nil(t, authConfig)
+}
+
+func TestRegistryAuthCredentialHelper(t *testing.T) {
+	bin := t.TempDir()
+	helper := "#!/bin/sh\nread server\necho \"{\\\"ServerURL\\\":\\\"$server\\\",\\\"Username\\\":\\\"helper\\\",\\\"Secret\\\":\\\"s3cret\\\"}\"\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, "docker-credential-fake"), []byte(helper), 0700))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	path := writeDockerConfig(t, `{"credHelpers": {"ghcr.io": "fake"}}`)
+	authConfig, err := NewRegistryAuth(nil, path).AuthConfigFor("ghcr.io/viam-soleng/app")
+	assert.NoError(t, err)
+	assert.Equal(t, "helper", authConfig.Username)
+	assert.Equal(t, "s3cret", authConfig.Password)
+
+	authConfig, err = NewRegistryAuth(nil, path).AuthConfigFor("ubuntu")
+	assert.NoError(t, err)
+	assert.Nil(t, authConfig)
+}
+
+func TestRegistryAuthBrokenCredsStore(t *testing.T) {
+	// docker-credential-missing isn't on the PATH
+	path := writeDockerConfig(t, `{
+		"credsStore": "missing",
+		"auths": {"ghcr.io": {"username": "gh", "password": "ghpass"}}
+	}`)
+	auth := NewRegistryAuth(nil, path)
+	auth.logger = logging.NewTestLogger(t)
+
+	authConfig, err := auth.AuthConfigFor("ghcr.io/viam-soleng/app")
+	assert.NoError(t, err)
+	assert.Equal(t, "gh", authConfig.Username)
+
+	// Public images are pulled anonymously
+	authConfig, err = auth.AuthConfigFor("ubuntu")
+	assert.NoError(t, err)
+	assert.Nil(t, authConfig)
+}
+
+func TestEncodedAuthEscapesPassword(t *testing.T) {
+	auth := NewRegistryAuth(map[string]*Credentials{"ghcr.io": {Username: "user", Password: `pa"ss\word`}}, writeDockerConfig(t, `{}`))
+	encoded, err := auth.EncodedAuthFor("ghcr.io/viam-soleng/app")
+	assert.NoError(t, err)
+
+	decoded, err := base64.URLEncoding.DecodeString(encoded)
+	assert.NoError(t, err)
+	var authConfig registry.AuthConfig
+	assert.NoError(t, json.Unmarshal(decoded, &authConfig))
+	assert.Equal(t, `pa"ss\word`, authConfig.Password)
+	assert.Equal(t, "ghcr.io", authConfig.ServerAddress)
+}
+
+func TestRegistryAuthPerRegistryCredentials(t *testing.T) {
+	auth := NewRegistryAuth(map[string]*Credentials{
+		"ghcr.io":           {Username: "gh", Password: "ghpass"},
+		"harbor.local:8443": {Username: "harbor", Password: "harborpass"},
+		"docker.io":         {Username: "hub", Password: "hubpass"},
+	}, writeDockerConfig(t, `{}`))
+
+	for imageName, expected := range map[string]string{
+		"ghcr.io/viam-soleng/app":    "gh",
+		"harbor.local:8443/team/app": "harbor",
+		"ubuntu":                     "hub",
+	} {
+		authConfig, err := auth.AuthConfigFor(imageName)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, authConfig.Username, imageName)
+	}
+
+	authConfig, err := auth.AuthConfigFor("quay.io/other/app")
+	assert.NoError(t, err)
+	assert.Nil(t, authConfig)
+}
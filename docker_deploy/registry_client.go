@@ -0,0 +1,348 @@
+package docker_deploy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+var ErrRegistryUnauthorized = errors.New("registry denied access")
+var ErrManifestNotFound = errors.New("manifest not found")
+var ErrPlatformNotInIndex = errors.New("no manifest for the platform in the image index")
+
+// Manifests larger than this are refused, real manifests are a few KB
+const maxManifestSize = 4 << 20
+
+// RegistryClient talks to the registry HTTP API directly, for what the Docker daemon doesn't expose such as layer
+// sizes and resolving tags without pulling. It authenticates with the same credentials used for pulls.
+type RegistryClient struct {
+	httpClient *http.Client
+	auth       *RegistryAuth
+}
+
+func NewRegistryClient(auth *RegistryAuth) *RegistryClient {
+	return &RegistryClient{httpClient: &http.Client{Timeout: 30 * time.Second}, auth: auth}
+}
+
+// RegistryManifest is an image manifest or an image index (manifest list), depending on MediaType
+type RegistryManifest struct {
+	MediaType   string               `json:"mediaType"`
+	Config      RegistryDescriptor   `json:"config"`
+	Layers      []RegistryDescriptor `json:"layers"`
+	Manifests   []RegistryDescriptor `json:"manifests"`
+	Annotations map[string]string    `json:"annotations,omitempty"`
+
+	// The digest of the manifest, from the Docker-Content-Digest header
+	Digest string `json:"-"`
+}
+
+type RegistryDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func (m *RegistryManifest) IsIndex() bool {
+	return m.MediaType == mediaTypeDockerManifestList || m.MediaType == mediaTypeOCIIndex || len(m.Manifests) > 0
+}
+
+// Size returns the compressed size of the image, the sum of its config and layers
+func (m *RegistryManifest) Size() int64 {
+	size := m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// ForPlatform returns the descriptor of the manifest for the platform from an index
+func (m *RegistryManifest) ForPlatform(platform Platform) (*RegistryDescriptor, error) {
+	for i := range m.Manifests {
+		if m.Manifests[i].Platform != nil && m.Manifests[i].Platform.Matches(platform) {
+			return &m.Manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", platform, ErrPlatformNotInIndex)
+}
+
+// GetManifest fetches a manifest by tag or digest
+func (rc *RegistryClient) GetManifest(ctx context.Context, imageName string, ref string) (*RegistryManifest, error) {
+	resp, err := rc.manifestRequest(ctx, http.MethodGet, imageName, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var manifest RegistryManifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse manifest %s of %s: %w", ref, imageName, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = resp.Header.Get("Content-Type")
+	}
+	manifest.Digest = resp.Header.Get("Docker-Content-Digest")
+	if manifest.Digest == "" && strings.HasPrefix(ref, "sha256:") {
+		manifest.Digest = ref
+	}
+	return &manifest, nil
+}
+
+// ResolveDigest returns the digest a tag currently points to, without pulling the image
+func (rc *RegistryClient) ResolveDigest(ctx context.Context, imageName string, tag string) (string, error) {
+	resp, err := rc.manifestRequest(ctx, http.MethodHead, imageName, tag)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	// Not every registry sets the header on HEAD requests, the digest is the hash of the manifest as served
+	resp, err = rc.manifestRequest(ctx, http.MethodGet, imageName, tag)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b)), nil
+}
+
+// GetPlatformManifest fetches a manifest, following an index to the manifest for the platform
+func (rc *RegistryClient) GetPlatformManifest(ctx context.Context, imageName string, ref string, platform Platform) (*RegistryManifest, error) {
+	manifest, err := rc.GetManifest(ctx, imageName, ref)
+	if err != nil || !manifest.IsIndex() {
+		return manifest, err
+	}
+	descriptor, err := manifest.ForPlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	return rc.GetManifest(ctx, imageName, descriptor.Digest)
+}
+
+// GetBlob fetches a blob, such as an image config, limited to maxSize bytes
+func (rc *RegistryClient) GetBlob(ctx context.Context, imageName string, digest string, maxSize int64) ([]byte, error) {
+	repo, base, err := registryRepository(imageName)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rc.do(ctx, http.MethodGet, imageName, fmt.Sprintf("%s/v2/%s/blobs/%s", base, repo, digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, maxSize))
+}
+
+func (rc *RegistryClient) manifestRequest(ctx context.Context, method string, imageName string, ref string) (*http.Response, error) {
+	repo, base, err := registryRepository(imageName)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Accept", strings.Join([]string{mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest}, ", "))
+	return rc.do(ctx, method, imageName, fmt.Sprintf("%s/v2/%s/manifests/%s", base, repo, ref), header)
+}
+
+// do sends the request, answering a Bearer or Basic auth challenge from the registry if there is one
+func (rc *RegistryClient) do(ctx context.Context, method string, imageName string, u string, header http.Header) (*http.Response, error) {
+	send := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return rc.httpClient.Do(req)
+	}
+
+	resp, err := send("")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		authConfig, err := rc.auth.AuthConfigFor(imageName)
+		if err != nil {
+			return nil, err
+		}
+		authorization, err := rc.authorize(ctx, challenge, authConfig)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = send(authorization); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return resp, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", imageName, ErrRegistryUnauthorized)
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", u, ErrManifestNotFound)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("registry returned %s for %s", resp.Status, u)
+	}
+}
+
+// authorize answers a WWW-Authenticate challenge, see https://distribution.github.io/distribution/spec/auth/token/
+func (rc *RegistryClient) authorize(ctx context.Context, challenge string, authConfig *registry.AuthConfig) (string, error) {
+	scheme, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if authConfig == nil {
+			return "", ErrRegistryUnauthorized
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(authConfig.Username, authConfig.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		if authConfig != nil && authConfig.RegistryToken != "" {
+			return "Bearer " + authConfig.RegistryToken, nil
+		}
+		token, err := rc.fetchToken(ctx, params, authConfig)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported auth challenge %q", ErrRegistryUnauthorized, scheme)
+	}
+}
+
+func (rc *RegistryClient) fetchToken(ctx context.Context, params map[string]string, authConfig *registry.AuthConfig) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("%w: invalid token realm", ErrRegistryUnauthorized)
+	}
+
+	var req *http.Request
+	if authConfig != nil && authConfig.IdentityToken != "" {
+		// Identity tokens are OAuth2 refresh tokens
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", authConfig.IdentityToken)
+		form.Set("service", params["service"])
+		form.Set("scope", params["scope"])
+		form.Set("client_id", "viam-docker-manager")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := realm.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		if params["scope"] != "" {
+			query.Set("scope", params["scope"])
+		}
+		realm.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if authConfig != nil && authConfig.Username != "" {
+			req.SetBasicAuth(authConfig.Username, authConfig.Password)
+		}
+	}
+
+	resp, err := rc.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token request returned %s", ErrRegistryUnauthorized, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// parseAuthChallenge parses `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+func parseAuthChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimSpace(rest), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+			rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
+
+// registryRepository returns the repository path and the base URL of the registry hosting an image
+func registryRepository(imageName string) (string, string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", "", err
+	}
+	host := reference.Domain(named)
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	// Like the Docker daemon, registries on the loopback interface are spoken to over plain HTTP
+	if hostname, _, err := net.SplitHostPort(host); (err == nil && isLoopback(hostname)) || isLoopback(host) {
+		scheme = "http"
+	}
+	return reference.Path(named), fmt.Sprintf("%s://%s", scheme, host), nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
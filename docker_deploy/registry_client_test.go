@@ -0,0 +1,178 @@
+package docker_deploy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry is a registry that only serves manifests and blobs, behind token auth if username is set
+type fakeRegistry struct {
+	*httptest.Server
+	username string
+	password string
+
+	mu        sync.Mutex
+	manifests map[string]fakeRegistryObject
+	blobs     map[string][]byte
+}
+
+type fakeRegistryObject struct {
+	mediaType string
+	body      []byte
+}
+
+func newFakeRegistry(t *testing.T, username string, password string) *fakeRegistry {
+	fr := &fakeRegistry{
+		username:  username,
+		password:  password,
+		manifests: map[string]fakeRegistryObject{},
+		blobs:     map[string][]byte{},
+	}
+	fr.Server = httptest.NewServer(http.HandlerFunc(fr.serve))
+	t.Cleanup(fr.Close)
+	return fr
+}
+
+// host returns the registry host, e.g. 127.0.0.1:41234
+func (fr *fakeRegistry) host() string {
+	return strings.TrimPrefix(fr.URL, "http://")
+}
+
+// image returns the name of an image in the registry, e.g. 127.0.0.1:41234/app
+func (fr *fakeRegistry) image(repo string) string {
+	return fmt.Sprintf("%s/%s", fr.host(), repo)
+}
+
+// addManifest stores a manifest under its digest and, if tag isn't empty, the tag, and returns the digest
+func (fr *fakeRegistry) addManifest(repo string, tag string, mediaType string, manifest interface{}) string {
+	body, _ := json.Marshal(manifest)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.manifests[repo+"@"+digest] = fakeRegistryObject{mediaType: mediaType, body: body}
+	if tag != "" {
+		fr.manifests[repo+":"+tag] = fakeRegistryObject{mediaType: mediaType, body: body}
+	}
+	return digest
+}
+
+func (fr *fakeRegistry) addBlob(repo string, body []byte) string {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.blobs[repo+"@"+digest] = body
+	return digest
+}
+
+func (fr *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if username, password, _ := r.BasicAuth(); username != fr.username || password != fr.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "fake-token"})
+		return
+	}
+	if fr.username != "" && r.Header.Get("Authorization") != "Bearer fake-token" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry"`, fr.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		repo, ref := path[:i], path[i+len("/manifests/"):]
+		separator := ":"
+		if strings.HasPrefix(ref, "sha256:") {
+			separator = "@"
+		}
+		object, ok := fr.manifests[repo+separator+ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", object.mediaType)
+		w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(object.body)))
+		w.Write(object.body)
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		body, ok := fr.blobs[path[:i]+"@"+path[i+len("/blobs/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// addMultiPlatformImage adds an index with an amd64 and an arm64 image, of 1000 and 100 bytes of layers
+func (fr *fakeRegistry) addMultiPlatformImage(repo string, tag string) string {
+	amd64 := fr.addManifest(repo, "", mediaTypeOCIManifest, RegistryManifest{
+		MediaType: mediaTypeOCIManifest,
+		Layers:    []RegistryDescriptor{{Digest: "sha256:a1", Size: 600}, {Digest: "sha256:a2", Size: 400}},
+	})
+	arm64 := fr.addManifest(repo, "", mediaTypeOCIManifest, RegistryManifest{
+		MediaType: mediaTypeOCIManifest,
+		Layers:    []RegistryDescriptor{{Digest: "sha256:b1", Size: 60}, {Digest: "sha256:b2", Size: 40}},
+	})
+	return fr.addManifest(repo, tag, mediaTypeOCIIndex, RegistryManifest{
+		MediaType: mediaTypeOCIIndex,
+		Manifests: []RegistryDescriptor{
+			{Digest: amd64, Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+			{Digest: arm64, Platform: &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		},
+	})
+}
+
+func TestRegistryClientPlatformManifest(t *testing.T) {
+	registry := newFakeRegistry(t, "user", "pass")
+	digest := registry.addMultiPlatformImage("team/app", "1.0")
+	imageName := registry.image("team/app")
+
+	client := NewRegistryClient(NewRegistryAuth(map[string]*Credentials{
+		registry.host(): {Username: "user", Password: "pass"},
+	}, writeDockerConfig(t, `{}`)))
+
+	index, err := client.GetManifest(context.Background(), imageName, "1.0")
+	assert.NoError(t, err)
+	assert.True(t, index.IsIndex())
+	assert.Equal(t, digest, index.Digest)
+
+	manifest, err := client.GetPlatformManifest(context.Background(), imageName, digest, Platform{OS: "linux", Architecture: "aarch64"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), manifest.Size())
+
+	_, err = client.GetPlatformManifest(context.Background(), imageName, digest, Platform{OS: "linux", Architecture: "armv7l"})
+	assert.ErrorIs(t, err, ErrPlatformNotInIndex)
+
+	_, err = client.GetManifest(context.Background(), imageName, "2.0")
+	assert.ErrorIs(t, err, ErrManifestNotFound)
+
+	anonymous := NewRegistryClient(NewRegistryAuth(nil, writeDockerConfig(t, `{}`)))
+	_, err = anonymous.GetManifest(context.Background(), imageName, "1.0")
+	assert.ErrorIs(t, err, ErrRegistryUnauthorized)
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	scheme, params := parseAuthChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/ubuntu:pull"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/ubuntu:pull",
+	}, params)
+}
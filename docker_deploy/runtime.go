@@ -0,0 +1,79 @@
+package docker_deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/client"
+)
+
+var ErrUnknownRuntime = errors.New("runtime must be docker or podman")
+
+// The container runtimes the module can manage containers with. Podman is used through its Docker compatible API.
+const (
+	RuntimeDocker = "docker"
+	RuntimePodman = "podman"
+)
+
+// The socket of the rootful Podman service, rootless services listen in $XDG_RUNTIME_DIR/podman instead
+const podmanRootfulSocket = "/run/podman/podman.sock"
+
+func (conf *Config) runtime() string {
+	if conf.Runtime == "" {
+		return RuntimeDocker
+	}
+	return conf.Runtime
+}
+
+func (conf *Config) validateRuntime() []error {
+	switch conf.runtime() {
+	case RuntimeDocker, RuntimePodman:
+		return nil
+	default:
+		return []error{fmt.Errorf("%w: %q", ErrUnknownRuntime, conf.Runtime)}
+	}
+}
+
+// podmanHost returns the address of the Podman service. CONTAINER_HOST takes precedence like it does for the podman
+// CLI, then the rootless socket of the user the module runs as, then the rootful socket.
+func podmanHost() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		socket := filepath.Join(dir, "podman", "podman.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+	return "unix://" + podmanRootfulSocket
+}
+
+// newRuntimeClient returns a client for the runtime's API. Docker is configured from the environment, like the docker
+// CLI, Podman's API version is negotiated as it lags behind the Docker API it implements.
+func newRuntimeClient(runtime string) (*client.Client, error) {
+	switch runtime {
+	case RuntimePodman:
+		return client.NewClientWithOpts(client.WithHost(podmanHost()), client.WithAPIVersionNegotiation())
+	case RuntimeDocker:
+		return client.NewClientWithOpts(client.FromEnv)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRuntime, runtime)
+	}
+}
+
+// ensureManager creates the manager for the config's runtime, unless the component already has one. dc.mu must be
+// held.
+func (dc *DockerConfig) ensureManager(conf *Config, auth *RegistryAuth) error {
+	if dc.manager != nil {
+		return nil
+	}
+	manager, err := NewLocalDockerManagerForRuntime(conf.runtime(), auth, dc.logger)
+	if err != nil {
+		return err
+	}
+	dc.manager = manager
+	return nil
+}
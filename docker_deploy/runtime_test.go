@@ -0,0 +1,59 @@
+package docker_deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/resource"
+)
+
+func TestValidateRuntime(t *testing.T) {
+	conf := validRunConfig()
+	for _, runtime := range []string{"", RuntimeDocker, RuntimePodman} {
+		conf.Runtime = runtime
+		_, err := conf.Validate("")
+		assert.NoError(t, err, runtime)
+	}
+
+	conf.Runtime = "containerd"
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrUnknownRuntime)
+}
+
+func TestPodmanHost(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CONTAINER_HOST", "")
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	assert.Equal(t, "unix://"+podmanRootfulSocket, podmanHost())
+
+	// The rootless service is used when it is running
+	socket := filepath.Join(dir, "podman", "podman.sock")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(socket), 0o700))
+	assert.NoError(t, os.WriteFile(socket, nil, 0o600))
+	assert.Equal(t, "unix://"+socket, podmanHost())
+
+	t.Setenv("CONTAINER_HOST", "tcp://robot.local:8888")
+	assert.Equal(t, "tcp://robot.local:8888", podmanHost())
+
+	cli, err := newRuntimeClient(RuntimePodman)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://robot.local:8888", cli.DaemonHost())
+}
+
+func TestRuntimeChangeRebuildsComponent(t *testing.T) {
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	dc.conf = *validRunConfig()
+	podman := validRunConfig()
+	podman.Runtime = RuntimePodman
+	err := dc.Reconfigure(context.Background(), nil, resource.Config{
+		Name:                "container0",
+		Model:               Model,
+		API:                 sensor.API,
+		ConvertedAttributes: podman,
+	})
+	assert.True(t, resource.IsMustRebuildError(err))
+}
@@ -0,0 +1,134 @@
+package docker_deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var ErrSecretOutsideDirectory = errors.New("secret files must be inside the secrets directory")
+
+// resolvedSecrets holds the values read from secret files. They are only ever kept in memory and must never be logged.
+type resolvedSecrets struct {
+	credentials map[string]*Credentials
+	env         []string
+}
+
+func (rs *resolvedSecrets) credentialsEqual(other *resolvedSecrets) bool {
+	if rs == nil || other == nil {
+		return rs == other
+	}
+	return credentialsEqual(rs.credentials, other.credentials)
+}
+
+func (rs *resolvedSecrets) envEqual(other *resolvedSecrets) bool {
+	if rs == nil || other == nil {
+		return rs == other
+	}
+	return reflect.DeepEqual(rs.env, other.env)
+}
+
+// secretsDirectory returns the directory secret files are resolved against, VIAM_MODULE_DATA unless secrets_dir is set
+func secretsDirectory(conf *Config) (string, error) {
+	if conf.SecretsDirectory != "" {
+		// The policy may have changed since the config was validated
+		if err := enforcePolicy(func(policy *Policy) []error {
+			return policy.checkSecretsDirectory(conf.SecretsDirectory)
+		}); err != nil {
+			return "", err
+		}
+		return conf.SecretsDirectory, nil
+	}
+	return moduleDataDirectory()
+}
+
+// hasSecretFiles reports whether the config reads anything from secret files
+func (conf *Config) hasSecretFiles() bool {
+	for _, creds := range conf.Credentials {
+		if creds != nil && creds.PasswordFile != "" {
+			return true
+		}
+	}
+	return conf.RunOptions != nil && len(conf.RunOptions.EnvFromFiles) > 0
+}
+
+// resolveSecrets reads every password_file and env_from_files entry of the config
+func resolveSecrets(conf *Config) (*resolvedSecrets, error) {
+	secrets := &resolvedSecrets{credentials: map[string]*Credentials{}}
+	dir := ""
+	if conf.hasSecretFiles() {
+		var err error
+		if dir, err = secretsDirectory(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	for host, creds := range conf.Credentials {
+		if creds == nil {
+			continue
+		}
+		resolved := &Credentials{Username: creds.Username, Password: creds.Password}
+		if creds.PasswordFile != "" {
+			password, err := readSecretFile(dir, creds.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("credentials for %s: %w", host, err)
+			}
+			resolved.Password = password
+		}
+		secrets.credentials[host] = resolved
+	}
+
+	if conf.RunOptions != nil {
+		for name, path := range conf.RunOptions.EnvFromFiles {
+			value, err := readSecretFile(dir, path)
+			if err != nil {
+				return nil, fmt.Errorf("env_from_files %s: %w", name, err)
+			}
+			secrets.env = append(secrets.env, fmt.Sprintf("%s=%s", name, value))
+		}
+		// Map iteration order is random, keep the env stable so it can be compared
+		sort.Strings(secrets.env)
+	}
+	return secrets, nil
+}
+
+// readSecretFile reads a secret from a file inside dir, without the trailing newline most editors add
+func readSecretFile(dir string, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	resolvedPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(resolvedDir, resolvedPath)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s: %w", path, ErrSecretOutsideDirectory)
+	}
+
+	b, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// withSecrets returns a copy of the config with the resolved secrets applied, it must never be stored in dc.conf
+func (conf *Config) withSecrets(secrets *resolvedSecrets) *Config {
+	resolved := *conf
+	resolved.Credentials = secrets.credentials
+	if conf.RunOptions != nil {
+		runOptions := *conf.RunOptions
+		runOptions.Env = append(append([]string{}, conf.RunOptions.Env...), secrets.env...)
+		resolved.RunOptions = &runOptions
+	}
+	return &resolved
+}
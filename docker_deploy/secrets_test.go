@@ -0,0 +1,63 @@
+package docker_deploy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "registry-password"), []byte("s3cret\n"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "api-key"), []byte("key"), 0600))
+
+	conf := validRunConfig()
+	conf.SecretsDirectory = dir
+	conf.Credentials = map[string]*Credentials{"ghcr.io": {Username: "user", PasswordFile: "registry-password"}}
+	conf.RunOptions.Env = []string{"PLAIN=1"}
+	conf.RunOptions.EnvFromFiles = map[string]string{"API_KEY": filepath.Join(dir, "api-key")}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	secrets, err := resolveSecrets(conf)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", secrets.credentials["ghcr.io"].Password)
+	assert.Equal(t, []string{"API_KEY=key"}, secrets.env)
+
+	resolved := conf.withSecrets(secrets)
+	assert.Equal(t, []string{"PLAIN=1", "API_KEY=key"}, resolved.RunOptions.Env)
+	// The original config must never hold the secret values
+	assert.Equal(t, []string{"PLAIN=1"}, conf.RunOptions.Env)
+	assert.Equal(t, "", conf.Credentials["ghcr.io"].Password)
+
+	// Changing the file changes the resolved secrets
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "api-key"), []byte("rotated"), 0600))
+	rotated, err := resolveSecrets(conf)
+	assert.NoError(t, err)
+	assert.False(t, secrets.envEqual(rotated))
+	assert.True(t, secrets.credentialsEqual(rotated))
+}
+
+func TestSecretsOutsideDirectory(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(outside, []byte("s3cret"), 0600))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+
+	conf := validRunConfig()
+	conf.SecretsDirectory = dir
+	conf.Credentials = map[string]*Credentials{"ghcr.io": {Username: "user", PasswordFile: "../password"}}
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrSecretOutsideDirectory)
+
+	conf.Credentials["ghcr.io"].PasswordFile = outside
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrSecretOutsideDirectory)
+
+	// Symlinks can't be used to escape the directory either
+	conf.Credentials["ghcr.io"].PasswordFile = "link"
+	_, err = resolveSecrets(conf)
+	assert.ErrorIs(t, err, ErrSecretOutsideDirectory)
+}
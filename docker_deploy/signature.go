@@ -0,0 +1,250 @@
+package docker_deploy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrPublicKeyRequired = errors.New("verify requires public_keys or public_key_files")
+var ErrInvalidPublicKey = errors.New("verify public keys must be PEM encoded ECDSA, RSA or Ed25519 public keys")
+var ErrImageNotSigned = errors.New("image is not signed")
+var ErrSignatureInvalid = errors.New("no valid signature from a trusted key")
+var ErrVerificationFailed = errors.New("signature verification failed")
+var ErrImageNotPinned = errors.New("image is not pinned by digest, so its signature can't be verified")
+var ErrImageFromBundle = errors.New("image was loaded from a bundle, so its signature can't be verified")
+
+const (
+	// cosign stores the signatures of an image under this annotation of the layers of the signature manifest
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	cosignSimpleSigningType   = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignSignatureType       = "cosign container image signature"
+)
+
+// Signature payloads are small JSON documents, anything larger isn't a payload cosign wrote
+const maxSignaturePayloadSize = 1 << 20
+
+// VerifyOptions checks that the pinned digest was signed with cosign by one of the keys before containers are created
+type VerifyOptions struct {
+	PublicKeys     []string `json:"public_keys"`
+	PublicKeyFiles []string `json:"public_key_files"`
+	Enforce        bool     `json:"enforce"`
+}
+
+// simpleSigningPayload is the part of the payload cosign signs that identifies the image
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+func (vo *VerifyOptions) validate() []error {
+	if vo == nil {
+		return nil
+	}
+	if len(vo.PublicKeys) == 0 && len(vo.PublicKeyFiles) == 0 {
+		return []error{ErrPublicKeyRequired}
+	}
+	var validationErrors []error
+	for _, key := range vo.PublicKeys {
+		if _, err := parsePublicKey([]byte(key)); err != nil {
+			validationErrors = append(validationErrors, err)
+		}
+	}
+	return validationErrors
+}
+
+// publicKeys parses the configured keys, relative key files are read from dir
+func (vo *VerifyOptions) publicKeys(dir string) ([]crypto.PublicKey, error) {
+	keys := make([]crypto.PublicKey, 0, len(vo.PublicKeys)+len(vo.PublicKeyFiles))
+	for _, key := range vo.PublicKeys {
+		publicKey, err := parsePublicKey([]byte(key))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, publicKey)
+	}
+	for _, path := range vo.PublicKeyFiles {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err := parsePublicKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, publicKey)
+	}
+	return keys, nil
+}
+
+// parsePublicKey parses a PEM encoded public key, like the cosign.pub written by `cosign generate-key-pair`
+func parsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrInvalidPublicKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, ErrInvalidPublicKey
+	}
+}
+
+func verifyWithKey(key crypto.PublicKey, payload []byte, signature []byte) bool {
+	digest := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, signature)
+	}
+	return false
+}
+
+// cosignSignatureTag returns the tag cosign stores the signatures of a digest under, e.g. sha256-04714a....sig
+func cosignSignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// VerifySignature checks that the registry holds a cosign signature of the digest made by one of the keys
+func (rc *RegistryClient) VerifySignature(ctx context.Context, imageName string, digest string, keys []crypto.PublicKey) error {
+	manifest, err := rc.GetManifest(ctx, imageName, cosignSignatureTag(digest))
+	if errors.Is(err, ErrManifestNotFound) {
+		return fmt.Errorf("%s@%s: %w", imageName, digest, ErrImageNotSigned)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != cosignSimpleSigningType {
+			continue
+		}
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			continue
+		}
+		payload, err := rc.GetBlob(ctx, imageName, layer.Digest, maxSignaturePayloadSize)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprintf("sha256:%x", sha256.Sum256(payload)) != layer.Digest {
+			continue
+		}
+
+		// The payload must be for this digest, or a signature of one image could be copied to another
+		var p simpleSigningPayload
+		if err := json.Unmarshal(payload, &p); err != nil || p.Critical.Type != cosignSignatureType || p.Critical.Image.DockerManifestDigest != digest {
+			continue
+		}
+		for _, key := range keys {
+			if verifyWithKey(key, payload, signature) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s@%s: %w", imageName, digest, ErrSignatureInvalid)
+}
+
+// verifyImages checks the signatures of the images of the config. A failure is only an error when enforce is set,
+// otherwise it is logged and reported in the readings.
+func (dc *DockerConfig) verifyImages(ctx context.Context, images []PinnedImage, conf *Config) error {
+	if conf.Verify == nil {
+		dc.setSignatureStatus(nil)
+		return nil
+	}
+	err := dc.verifySignatures(ctx, images, conf)
+	status := map[string]interface{}{
+		"verified":    err == nil,
+		"enforced":    conf.Verify.Enforce,
+		"verified_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		status["error"] = err.Error()
+	}
+	dc.setSignatureStatus(status)
+
+	if err == nil {
+		dc.logger.Infof("Verified the signatures of %d image(s)", len(images))
+		return nil
+	}
+	if conf.Verify.Enforce {
+		return fmt.Errorf("%w: %w", ErrVerificationFailed, err)
+	}
+	dc.logger.Warnf("Signature verification failed, starting anyway because verify.enforce is off: %v", err)
+	return nil
+}
+
+func (dc *DockerConfig) verifySignatures(ctx context.Context, images []PinnedImage, conf *Config) error {
+	// Services that reference their image by tag could run anything, so they fail verification
+	unpinned, err := unpinnedComposeServices(conf)
+	if err != nil {
+		return err
+	}
+	if len(unpinned) > 0 {
+		return fmt.Errorf("compose services %s: %w", strings.Join(unpinned, ", "), ErrImageNotPinned)
+	}
+	dir := ""
+	if len(conf.Verify.PublicKeyFiles) > 0 {
+		if dir, err = secretsDirectory(conf); err != nil {
+			return err
+		}
+	}
+	keys, err := conf.Verify.publicKeys(dir)
+	if err != nil {
+		return err
+	}
+	client := registryClientFor(conf)
+	for _, image := range images {
+		// The signature is for the image in the registry, nothing ties it to an image loaded from a bundle
+		if loadedFromBundle(image.RepoDigest) {
+			return fmt.Errorf("%s: %w", image, ErrImageFromBundle)
+		}
+		if err := client.VerifySignature(ctx, image.ImageName, image.RepoDigest, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dc *DockerConfig) setSignatureStatus(status map[string]interface{}) {
+	dc.pullMu.Lock()
+	defer dc.pullMu.Unlock()
+	dc.signatureStatus = status
+}
+
+func (dc *DockerConfig) getSignatureStatus() map[string]interface{} {
+	dc.pullMu.Lock()
+	defer dc.pullMu.Unlock()
+	return dc.signatureStatus
+}
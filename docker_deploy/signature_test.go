@@ -0,0 +1,135 @@
+package docker_deploy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newCosignKey generates a key pair like `cosign generate-key-pair`, and returns the key and its PEM public key
+func newCosignKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// sign stores a signature of digest by key in the registry, the way `cosign sign --key` does
+func (fr *fakeRegistry) sign(t *testing.T, repo string, digest string, key *ecdsa.PrivateKey) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, fr.image(repo), digest))
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	assert.NoError(t, err)
+
+	fr.addManifest(repo, cosignSignatureTag(digest), mediaTypeOCIManifest, RegistryManifest{
+		MediaType: mediaTypeOCIManifest,
+		Layers: []RegistryDescriptor{{
+			MediaType:   cosignSimpleSigningType,
+			Digest:      fr.addBlob(repo, payload),
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signature)},
+		}},
+	})
+}
+
+func TestVerifySignature(t *testing.T) {
+	registry := newFakeRegistry(t, "", "")
+	signed := registry.addMultiPlatformImage("app", "1.0")
+	unsigned := registry.addMultiPlatformImage("unsigned", "1.0")
+	key, publicKey := newCosignKey(t)
+	otherKey, otherPublicKey := newCosignKey(t)
+	registry.sign(t, "app", signed, key)
+
+	client := NewRegistryClient(NewRegistryAuth(nil, writeDockerConfig(t, `{}`)))
+	keys, err := (&VerifyOptions{PublicKeys: []string{otherPublicKey, publicKey}}).publicKeys("")
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.VerifySignature(context.Background(), registry.image("app"), signed, keys))
+	err = client.VerifySignature(context.Background(), registry.image("unsigned"), unsigned, keys)
+	assert.ErrorIs(t, err, ErrImageNotSigned)
+
+	// A signature by an untrusted key
+	registry.sign(t, "unsigned", unsigned, otherKey)
+	err = client.VerifySignature(context.Background(), registry.image("unsigned"), unsigned, keys[1:])
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+}
+
+func TestVerifyEnforced(t *testing.T) {
+	registry := newFakeRegistry(t, "", "")
+	digest := registry.addMultiPlatformImage("app", "1.0")
+	_, publicKey := newCosignKey(t)
+
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	conf := &Config{
+		ImageName:        registry.image("app"),
+		RepoDigest:       digest,
+		RunOptions:       &RunOptions{},
+		DockerConfigPath: writeDockerConfig(t, `{}`),
+		Verify:           &VerifyOptions{PublicKeys: []string{publicKey}},
+	}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	// Unsigned images are only reported while verify isn't enforced
+	images := []PinnedImage{{ImageName: conf.ImageName, RepoDigest: digest}}
+	assert.NoError(t, dc.verifyImages(context.Background(), images, conf))
+	assert.Equal(t, false, dc.getSignatureStatus()["verified"])
+
+	// The fake manager panics if a container is created
+	conf.Verify.Enforce = true
+	dc.startDownload(context.Background(), conf)
+	assert.Equal(t, 1, manager.pulls)
+	assert.ErrorIs(t, dc.getPullFailure(), ErrImageNotSigned)
+
+	readings, err := dc.Readings(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "verification_failed", readings["state"])
+	ready, err := dc.Ready(context.Background(), nil)
+	assert.False(t, ready)
+	assert.ErrorIs(t, err, ErrVerificationFailed)
+}
+
+func TestVerifyRefusesUnpinnedComposeImages(t *testing.T) {
+	_, publicKey := newCosignKey(t)
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	conf := &Config{
+		ImageName:  "ubuntu",
+		RepoDigest: "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		ComposeOptions: &ComposeOptions{ComposeFile: []string{
+			"services:",
+			"  app:",
+			"    image: ubuntu@sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+			"  worker:",
+			"    image: ubuntu:latest",
+		}},
+		Verify: &VerifyOptions{PublicKeys: []string{publicKey}, Enforce: true},
+	}
+
+	images, err := pinnedImages(conf)
+	assert.NoError(t, err)
+	err = dc.verifyImages(context.Background(), images, conf)
+	assert.ErrorIs(t, err, ErrVerificationFailed)
+	assert.ErrorIs(t, err, ErrImageNotPinned)
+	assert.ErrorContains(t, err, "compose services worker")
+}
+
+func TestValidateVerify(t *testing.T) {
+	conf := &Config{ImageName: "ubuntu", RepoDigest: "sha256:abc", RunOptions: &RunOptions{}, Verify: &VerifyOptions{}}
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrPublicKeyRequired)
+
+	conf.Verify.PublicKeys = []string{"not a key"}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+}
@@ -0,0 +1,135 @@
+package docker_deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/sys/unix"
+)
+
+var ErrInvalidStopSignal = errors.New("stop_signal must be a signal name like SIGINT or a signal number")
+var ErrStopTimeoutNegative = errors.New("stop_timeout_seconds must not be negative")
+var ErrUnknownOnClose = errors.New("on_close must be stop, remove or leave_running")
+
+// What happens to the containers when the component closes, which is also when the module or viam-server restarts
+const (
+	OnCloseStop         = "stop"
+	OnCloseRemove       = "remove"
+	OnCloseLeaveRunning = "leave_running"
+)
+
+// The daemon waits this long for a container to stop before killing it, unless stop_timeout_seconds is set
+const defaultStopTimeout = 10 * time.Second
+
+func validStopSignal(signal string) bool {
+	if n, err := strconv.Atoi(signal); err == nil {
+		return n > 0 && n < 65
+	}
+	signal = strings.ToUpper(signal)
+	if !strings.HasPrefix(signal, "SIG") {
+		signal = "SIG" + signal
+	}
+	return unix.SignalNum(signal) != 0
+}
+
+func (conf *Config) validateStop() []error {
+	var validationErrors []error
+	if conf.StopSignal != "" && !validStopSignal(conf.StopSignal) {
+		validationErrors = append(validationErrors, fmt.Errorf("%w: %q", ErrInvalidStopSignal, conf.StopSignal))
+	}
+	if conf.StopTimeout < 0 {
+		validationErrors = append(validationErrors, ErrStopTimeoutNegative)
+	}
+	switch conf.onClose() {
+	case OnCloseStop, OnCloseRemove, OnCloseLeaveRunning:
+	default:
+		validationErrors = append(validationErrors, fmt.Errorf("%w: %q", ErrUnknownOnClose, conf.OnClose))
+	}
+	return validationErrors
+}
+
+func (conf *Config) onClose() string {
+	if conf.OnClose == "" {
+		return OnCloseStop
+	}
+	return conf.OnClose
+}
+
+// stopOptions returns how the containers are stopped, unset fields use the container's or the daemon's defaults
+func (conf *Config) stopOptions() container.StopOptions {
+	options := container.StopOptions{Signal: conf.StopSignal}
+	if conf.StopTimeout > 0 {
+		timeout := conf.StopTimeout
+		options.Timeout = &timeout
+	}
+	return options
+}
+
+// stopTimeout is how long a container is given to stop, and how long pre_stop may take before that
+func (conf *Config) stopTimeout() time.Duration {
+	if conf.StopTimeout > 0 {
+		return time.Duration(conf.StopTimeout) * time.Second
+	}
+	return defaultStopTimeout
+}
+
+// stopContainer runs the pre_stop command of the config in the container, then stops it with the config's signal and
+// timeout. A failing pre_stop command doesn't keep the container from being stopped.
+func (dc *DockerConfig) stopContainer(conf *Config, containerId string) error {
+	if len(conf.PreStop) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), conf.stopTimeout())
+		exitCode, output, err := dc.manager.ExecContainer(ctx, containerId, conf.PreStop)
+		cancel()
+		if err != nil {
+			dc.logger.Warnf("Unable to run pre_stop in container %s: %v", containerId, err)
+		} else if exitCode != 0 {
+			dc.logger.Warnf("pre_stop in container %s exited with %d: %s", containerId, exitCode, strings.TrimSpace(output))
+		}
+	}
+	return dc.manager.StopContainer(containerId, conf.stopOptions())
+}
+
+// containerLabels returns the labels the containers of the component are created with
+func (dc *DockerConfig) containerLabels() map[string]string {
+	return map[string]string{managedLabel: "true", componentLabel: dc.Name().String()}
+}
+
+// removePreviousContainers stops and removes the containers an earlier instance of the component left behind, e.g.
+// with on_close leave_running, so they don't run next to the new ones or hold on to their names
+func (dc *DockerConfig) removePreviousContainers(ctx context.Context, conf *Config) {
+	ids, err := dc.manager.ListLabeledContainers(ctx, dc.containerLabels())
+	if err != nil {
+		dc.logger.Warnf("Unable to list the previous containers of the component: %v", err)
+		return
+	}
+	current := map[string]bool{}
+	for _, container := range dc.containers {
+		if container != nil {
+			current[container.GetContainerId()] = true
+		}
+	}
+	for _, id := range ids {
+		if current[id] {
+			continue
+		}
+		dc.logger.Infof("Replacing container %s left behind by a previous instance of the component", id)
+		if err := dc.stopContainer(conf, id); err != nil {
+			dc.logger.Error(err)
+		}
+		if err := dc.manager.RemoveContainer(id); err != nil {
+			dc.logger.Error(err)
+		}
+	}
+}
+
+// stopConfig returns the config the running containers were created with, which decides how they are stopped
+func (dc *DockerConfig) stopConfig() Config {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	return dc.appliedConf
+}
@@ -0,0 +1,127 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStop(t *testing.T) {
+	conf := validRunConfig()
+	for _, signal := range []string{"SIGINT", "INT", "sigusr1", "2", ""} {
+		conf.StopSignal = signal
+		_, err := conf.Validate("")
+		assert.NoError(t, err, signal)
+	}
+
+	conf.StopSignal = "SIGFOO"
+	conf.StopTimeout = -1
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrInvalidStopSignal)
+	assert.ErrorIs(t, err, ErrStopTimeoutNegative)
+}
+
+func TestStopContainerRunsPreStop(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	conf := validRunConfig()
+	conf.StopSignal = "SIGINT"
+	conf.StopTimeout = 30
+	conf.PreStop = []string{"rosbag", "flush"}
+
+	assert.NoError(t, dc.stopContainer(conf, "c1"))
+	assert.Equal(t, []string{"exec c1 rosbag flush", "stop c1"}, manager.containerEvents)
+	timeout := 30
+	assert.Equal(t, []container.StopOptions{{Signal: "SIGINT", Timeout: &timeout}}, manager.stopOptions)
+
+	// A failing pre_stop still stops the container
+	manager.execExitCode = 1
+	assert.NoError(t, dc.stopContainer(conf, "c2"))
+	assert.Equal(t, "stop c2", manager.containerEvents[len(manager.containerEvents)-1])
+
+	// Without settings the container's own stop signal and timeout are used
+	assert.NoError(t, dc.stopContainer(validRunConfig(), "c3"))
+	assert.Equal(t, container.StopOptions{}, manager.stopOptions[2])
+}
+
+func TestStopsUseTheConfigTheContainersWereCreatedWith(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	dc.appliedConf = *validRunConfig()
+	dc.appliedConf.StopSignal = "SIGINT"
+	dc.appliedConf.PreStop = []string{"flush"}
+	dc.containers = []DockerContainer{&fakeDockerContainer{id: "c1"}}
+
+	// Stops for a restore
+	assert.NoError(t, dc.quiesce(true, func() error { return nil }))
+	assert.Equal(t, []string{"exec c1 flush", "stop c1", "start c1"}, manager.containerEvents)
+
+	manager.containerEvents = nil
+	assert.NoError(t, dc.Close(context.Background()))
+	assert.Equal(t, []string{"exec c1 flush", "stop c1"}, manager.containerEvents)
+	for _, options := range manager.stopOptions {
+		assert.Equal(t, "SIGINT", options.Signal)
+	}
+}
+
+func TestCloseAppliesOnClose(t *testing.T) {
+	for _, tc := range []struct {
+		onClose  string
+		events   []string
+		released bool
+	}{
+		{"", []string{"stop c1"}, true},
+		{OnCloseRemove, []string{"stop c1", "remove c1"}, true},
+		{OnCloseLeaveRunning, nil, false},
+	} {
+		manager := newFakeDockerManager()
+		dc := newTestDockerConfig(t, manager)
+		dc.appliedConf = *validRunConfig()
+		dc.appliedConf.OnClose = tc.onClose
+		dc.appliedConf.RunOptions.Networks = []NetworkOptions{{Name: "backend"}}
+		dc.containers = []DockerContainer{&fakeDockerContainer{id: "c1"}}
+		moduleNetworks.set(dc.Name().String(), networkNames(&dc.appliedConf))
+		assert.NoError(t, dc.ensureNetworks(context.Background(), &dc.appliedConf))
+
+		assert.NoError(t, dc.Close(context.Background()))
+		assert.Equal(t, tc.events, manager.containerEvents, tc.onClose)
+		// Containers left running keep their networks
+		assert.Equal(t, tc.released, len(manager.removedNetworks) == 1, tc.onClose)
+		moduleNetworks.remove(dc.Name().String())
+	}
+
+	conf := validRunConfig()
+	conf.OnClose = "delete"
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrUnknownOnClose)
+}
+
+func TestReconfigureReplacesPreviousContainers(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	conf := &Config{
+		ImageName:  "ubuntu",
+		RepoDigest: "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		ComposeOptions: &ComposeOptions{ComposeFile: []string{
+			"services:",
+			"  app:",
+			"    image: ubuntu@sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		}},
+	}
+	// An earlier instance of the component left app running, another component owns worker
+	manager.addContainer("app", dc.containerLabels())
+	manager.addContainer("worker", map[string]string{managedLabel: "true", componentLabel: "other"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer dc.stopSupervisors()
+	defer cancel()
+	dc.finishReconfigure(ctx, conf)
+	assert.Equal(t, []string{"stop app", "remove app", "create app"}, manager.containerEvents)
+	assert.Len(t, dc.containers, 1)
+
+	ids, err := manager.ListLabeledContainers(ctx, map[string]string{managedLabel: "true"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app", "worker"}, ids)
+}
@@ -0,0 +1,109 @@
+package docker_deploy
+
+import (
+	"context"
+	"time"
+
+	viamutils "go.viam.com/utils"
+)
+
+// How often a supervisor checks that its container is running
+const supervisorInterval = 10 * time.Second
+
+// supervisor starts its container again when it stops, until the containers are replaced or the component closes
+type supervisor struct {
+	container DockerContainer
+	runOnce   bool
+}
+
+// superviseContainers replaces the supervisors with one for each container, tied to ctx, the context of the reconfigure
+// that created the containers
+func (dc *DockerConfig) superviseContainers(ctx context.Context, containers []DockerContainer, runOnce bool) {
+	dc.stopSupervisors()
+	ctx, cancel := context.WithCancel(ctx)
+	supervisors := make([]*supervisor, 0, len(containers))
+	for _, container := range containers {
+		s := &supervisor{container: container, runOnce: runOnce}
+		supervisors = append(supervisors, s)
+		// Added before the supervisor starts so stopSupervisors can't miss it
+		dc.wg.Add(1)
+		viamutils.PanicCapturingGo(func() {
+			defer dc.wg.Done()
+			dc.supervise(ctx, s)
+		})
+	}
+	dc.supervisorMu.Lock()
+	defer dc.supervisorMu.Unlock()
+	dc.supervisors = supervisors
+	dc.supervisorCancelFunc = cancel
+}
+
+// stopSupervisors stops the supervisors and waits for them to return, so they can't start containers that are being
+// stopped
+func (dc *DockerConfig) stopSupervisors() {
+	dc.supervisorMu.Lock()
+	if dc.supervisorCancelFunc != nil {
+		dc.supervisorCancelFunc()
+	}
+	dc.supervisors = nil
+	dc.supervisorCancelFunc = nil
+	dc.supervisorMu.Unlock()
+	dc.wg.Wait()
+}
+
+// supervisedContainers returns the ids of the containers that have a supervisor
+func (dc *DockerConfig) supervisedContainers() []string {
+	dc.supervisorMu.Lock()
+	defer dc.supervisorMu.Unlock()
+	var ids []string
+	for _, s := range dc.supervisors {
+		ids = append(ids, s.container.GetContainerId())
+	}
+	return ids
+}
+
+func (dc *DockerConfig) supervise(ctx context.Context, s *supervisor) {
+	id := s.container.GetContainerId()
+	for {
+		select {
+		case <-ctx.Done():
+			dc.logger.Debugf("Stopped supervising container %s", id)
+			return
+		case <-time.After(supervisorInterval):
+		}
+
+		isRunning, err := s.container.IsRunning()
+		if err != nil {
+			dc.logger.Error(err)
+			continue
+		}
+		if isRunning || !dc.shouldRun(s) {
+			continue
+		}
+		dc.logger.Debugf("Container %s not running. Starting...", id)
+		if err := dc.manager.StartContainer(id); err != nil {
+			dc.logger.Error(err)
+			continue
+		}
+		if err := s.container.SetHasRun(); err != nil {
+			dc.logger.Error(err)
+		}
+	}
+}
+
+// shouldRun reports whether the supervisor should start its container
+func (dc *DockerConfig) shouldRun(s *supervisor) bool {
+	// Containers are stopped on purpose while a volume is backed up or restored
+	if dc.isQuiesced() {
+		return false
+	}
+	// If the image should run once only, we don't want to start it if it has already run
+	if s.runOnce {
+		hasRun, err := s.container.GetHasRun()
+		if err != nil {
+			dc.logger.Error(err)
+		}
+		return !hasRun
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorsFollowReconfigure(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	conf := validRunConfig()
+	manager.images[conf.RepoDigest] = true
+	// Reconfigure holds the lock while it calls reconfigure
+	reconfigure := func(conf *Config) {
+		t.Helper()
+		dc.mu.Lock()
+		defer dc.mu.Unlock()
+		assert.NoError(t, dc.reconfigure(conf, windowStatus{open: true}))
+	}
+	supervised := func(ids ...string) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(ids, dc.supervisedContainers())
+		}, 5*time.Second, 10*time.Millisecond, "supervising %v, not %v", dc.supervisedContainers(), ids)
+	}
+
+	reconfigure(conf)
+	supervised("c1")
+
+	// Recreated containers get new supervisors, the old ones stop with the containers they supervised
+	changed := validRunConfig()
+	changed.RunOptions.EntryPointArgs = []string{"echo", "bye"}
+	reconfigure(changed)
+	supervised("c2")
+	assert.Subset(t, manager.containerEvents, []string{"stop c1", "remove c1", "create c2"})
+
+	// A change that keeps the containers keeps their supervisors
+	probed := *changed
+	probed.Probes = []ProbeOptions{{Type: "tcp", Port: 8080}}
+	reconfigure(&probed)
+	supervised("c2")
+
+	compose := composeConfig("")
+	reconfigure(compose)
+	supervised("app", "worker")
+
+	downloadOnly := validRunConfig()
+	downloadOnly.DownloadOnly = true
+	reconfigure(downloadOnly)
+	supervised()
+
+	reconfigure(conf)
+	supervised("c3")
+	assert.NoError(t, dc.Close(context.Background()))
+	assert.Empty(t, dc.supervisedContainers())
+}
+
+func TestSupervisorsRunOnce(t *testing.T) {
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	container := &fakeDockerContainer{id: "c1"}
+	s := &supervisor{container: container, runOnce: true}
+	assert.True(t, dc.shouldRun(s))
+	assert.NoError(t, container.SetHasRun())
+	assert.False(t, dc.shouldRun(s))
+	assert.True(t, dc.shouldRun(&supervisor{container: container}))
+}
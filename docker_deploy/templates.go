@@ -0,0 +1,121 @@
+package docker_deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+var ErrUndefinedVariable = errors.New("undefined variable")
+
+// The name of the component, the other documented variables are set by viam-server in the module's environment
+const componentNameVariable = "COMPONENT_NAME"
+
+var moduleEnvVariables = []string{"VIAM_MODULE_DATA", "VIAM_MACHINE_PART_ID"}
+
+// ${NAME}, ${NAME:-default} or $$ for a literal $
+var templatePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// TemplateOptions turns on the expansion of variables. Only the documented variables and the module's environment
+// variables listed in env are expanded, so a config can't read secrets such as the module's API key.
+type TemplateOptions struct {
+	Env []string `json:"env"`
+}
+
+// lookup returns the values variables are expanded to for the component, or nil if templates are off
+func (to *TemplateOptions) lookup(componentName string) func(string) (string, bool) {
+	if to == nil {
+		return nil
+	}
+	allowed := map[string]bool{}
+	for _, name := range append(append([]string{}, moduleEnvVariables...), to.Env...) {
+		allowed[name] = true
+	}
+	return func(name string) (string, bool) {
+		if name == componentNameVariable {
+			return componentName, true
+		}
+		if !allowed[name] {
+			return "", false
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// expandTemplate expands the variables in s, and returns the names of the variables that aren't defined
+func expandTemplate(s string, lookup func(string) (string, bool)) (string, []string) {
+	var undefined []string
+	expanded := templatePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := templatePattern.FindStringSubmatch(match)
+		if value, ok := lookup(groups[1]); ok {
+			return value
+		}
+		if groups[2] != "" {
+			return groups[3]
+		}
+		undefined = append(undefined, groups[1])
+		return match
+	})
+	return expanded, undefined
+}
+
+// withTemplates returns a copy of the config with the variables in env, entry_point_args, host_options.Binds and
+// the compose file expanded. Variables that aren't defined are left as they are and reported. Without a lookup,
+// because templates are off, the config is returned as it is.
+func (conf *Config) withTemplates(lookup func(string) (string, bool)) (*Config, []error) {
+	if lookup == nil {
+		unchanged := *conf
+		return &unchanged, nil
+	}
+	var templateErrors []error
+	expand := func(field string, values []string) []string {
+		if values == nil {
+			return nil
+		}
+		undefined := map[string]bool{}
+		expanded := make([]string, len(values))
+		for i, v := range values {
+			var names []string
+			expanded[i], names = expandTemplate(v, lookup)
+			for _, name := range names {
+				undefined[name] = true
+			}
+		}
+		names := make([]string, 0, len(undefined))
+		for name := range undefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			templateErrors = append(templateErrors, fmt.Errorf("%s: %w: ${%s}", field, ErrUndefinedVariable, name))
+		}
+		return expanded
+	}
+
+	expanded := *conf
+	if conf.RunOptions != nil {
+		runOptions := *conf.RunOptions
+		runOptions.Env = expand("env", conf.RunOptions.Env)
+		runOptions.EntryPointArgs = expand("entry_point_args", conf.RunOptions.EntryPointArgs)
+		if binds, ok := conf.RunOptions.HostOptions["Binds"].(string); ok {
+			hostOptions := make(map[string]interface{}, len(conf.RunOptions.HostOptions))
+			for k, v := range conf.RunOptions.HostOptions {
+				hostOptions[k] = v
+			}
+			hostOptions["Binds"] = expand("host_options.Binds", []string{binds})[0]
+			runOptions.HostOptions = hostOptions
+		}
+		expanded.RunOptions = &runOptions
+	}
+	if conf.ComposeOptions != nil {
+		composeOptions := *conf.ComposeOptions
+		composeOptions.ComposeFile = expand("compose_file", conf.ComposeOptions.ComposeFile)
+		expanded.ComposeOptions = &composeOptions
+	}
+	return &expanded, templateErrors
+}
@@ -0,0 +1,112 @@
+package docker_deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		value, ok := map[string]string{"VIAM_MODULE_DATA": "/opt/data", "EMPTY": ""}[name]
+		return value, ok
+	}
+	for template, expected := range map[string]string{
+		"${VIAM_MODULE_DATA}/maps:/maps": "/opt/data/maps:/maps",
+		"LEVEL=${LOG_LEVEL:-info}":       "LEVEL=info",
+		"EMPTY=${EMPTY:-default}":        "EMPTY=",
+		"PRICE=$$5 $HOME":                "PRICE=$5 $HOME",
+	} {
+		expanded, undefined := expandTemplate(template, lookup)
+		assert.Equal(t, expected, expanded)
+		assert.Empty(t, undefined)
+	}
+
+	expanded, undefined := expandTemplate("${MISSING}/${MISSING}", lookup)
+	assert.Equal(t, "${MISSING}/${MISSING}", expanded)
+	assert.Equal(t, []string{"MISSING", "MISSING"}, undefined)
+}
+
+func TestWithTemplates(t *testing.T) {
+	t.Setenv("VIAM_MODULE_DATA", "/opt/data")
+	t.Setenv("VIAM_MACHINE_PART_ID", "part-1")
+	conf := validRunConfig()
+	conf.RunOptions.Env = []string{"PART=${VIAM_MACHINE_PART_ID}"}
+	conf.RunOptions.EntryPointArgs = []string{"--name", "${COMPONENT_NAME}"}
+	conf.RunOptions.HostOptions = map[string]interface{}{"Binds": "${VIAM_MODULE_DATA}/${COMPONENT_NAME}:/data", "NetworkMode": "host", "AutoRemove": false}
+
+	// Templates are off unless the config turns them on
+	expanded, templateErrors := conf.withTemplates(conf.Templates.lookup("camera"))
+	assert.Empty(t, templateErrors)
+	assert.Equal(t, conf.RunOptions, expanded.RunOptions)
+
+	conf.Templates = &TemplateOptions{}
+	expanded, templateErrors = conf.withTemplates(conf.Templates.lookup("camera"))
+	assert.Empty(t, templateErrors)
+	assert.Equal(t, []string{"PART=part-1"}, expanded.RunOptions.Env)
+	assert.Equal(t, []string{"--name", "camera"}, expanded.RunOptions.EntryPointArgs)
+	assert.Equal(t, "/opt/data/camera:/data", expanded.RunOptions.HostOptions["Binds"])
+	assert.Equal(t, "host", expanded.RunOptions.HostOptions["NetworkMode"])
+	// The config itself is left as it is
+	assert.Equal(t, "${VIAM_MODULE_DATA}/${COMPONENT_NAME}:/data", conf.RunOptions.HostOptions["Binds"])
+
+	compose := &Config{
+		ImageName:  "ubuntu",
+		RepoDigest: "sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+		ComposeOptions: &ComposeOptions{ComposeFile: []string{
+			"services:",
+			"  app:",
+			"    image: ubuntu@sha256:04714a1bfbb2d8b5390b5cc0c055e48ebfabd4aa395821b860730ff3277ed74a",
+			"    command: ['--name', '${COMPONENT_NAME}', '--price', '$$5']",
+			"    volumes:",
+			"      - ${VIAM_MODULE_DATA}/maps:/maps",
+		}},
+		Templates: &TemplateOptions{},
+	}
+	expanded, templateErrors = compose.withTemplates(compose.Templates.lookup("camera"))
+	assert.Empty(t, templateErrors)
+	project, err := loadComposeProject(expanded.ImageName, expanded.ComposeOptions.ComposeFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--name", "camera", "--price", "$5"}, []string(project.Services[0].Command))
+	assert.Equal(t, "/opt/data/maps", project.Services[0].Volumes[0].Source)
+}
+
+func TestTemplatesOnlyExpandAllowedVariables(t *testing.T) {
+	t.Setenv("VIAM_API_KEY", "secret")
+	t.Setenv("LOG_LEVEL", "debug")
+	conf := validRunConfig()
+	conf.RunOptions.Env = []string{"KEY=${VIAM_API_KEY}", "LEVEL=${LOG_LEVEL}"}
+	conf.Templates = &TemplateOptions{Env: []string{"LOG_LEVEL"}}
+
+	expanded, templateErrors := conf.withTemplates(conf.Templates.lookup("camera"))
+	assert.Equal(t, []string{"KEY=${VIAM_API_KEY}", "LEVEL=debug"}, expanded.RunOptions.Env)
+	assert.Len(t, templateErrors, 1)
+	assert.ErrorContains(t, templateErrors[0], "env: undefined variable: ${VIAM_API_KEY}")
+}
+
+func TestValidateUndefinedVariables(t *testing.T) {
+	conf := validRunConfig()
+	conf.Templates = &TemplateOptions{Env: []string{"UNDEFINED_TOKEN", "UNDEFINED_DIR"}}
+	conf.RunOptions.Env = []string{"TOKEN=${UNDEFINED_TOKEN}", "NAME=${COMPONENT_NAME}", "LEVEL=${LOG_LEVEL:-info}"}
+	conf.RunOptions.HostOptions = map[string]interface{}{"Binds": "${UNDEFINED_DIR}:/data", "NetworkMode": "host", "AutoRemove": false}
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrUndefinedVariable)
+	assert.ErrorContains(t, err, "env: undefined variable: ${UNDEFINED_TOKEN}")
+	assert.ErrorContains(t, err, "host_options.Binds: undefined variable: ${UNDEFINED_DIR}")
+	assert.NotContains(t, err.Error(), "COMPONENT_NAME")
+	assert.NotContains(t, err.Error(), "LOG_LEVEL")
+
+	t.Setenv("UNDEFINED_TOKEN", "token")
+	t.Setenv("UNDEFINED_DIR", "/srv")
+	_, err = conf.Validate("")
+	assert.NoError(t, err)
+}
+
+func TestPolicyAppliesToExpandedBinds(t *testing.T) {
+	writePolicy(t, `{"allowed_bind_prefixes": ["/srv"]}`)
+	conf := validRunConfig()
+	conf.Templates = &TemplateOptions{}
+	conf.RunOptions.HostOptions = map[string]interface{}{"Binds": "${VIAM_MODULE_DATA}:/data", "NetworkMode": "host", "AutoRemove": false}
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrPolicyViolation)
+}
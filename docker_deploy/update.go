@@ -0,0 +1,172 @@
+package docker_deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrTagNotConfigured = errors.New("updates are only available for images configured by tag")
+
+// How often the update watcher checks whether an update check is due
+const updateWatchInterval = time.Minute
+
+// registryClientFor returns a client that authenticates with the resolved credentials of the config
+func registryClientFor(conf *Config) *RegistryClient {
+	return NewRegistryClient(NewRegistryAuth(conf.Credentials, conf.DockerConfigPath))
+}
+
+// pinTag carries the digest the tag was resolved to over to a new config for the same image and tag. Configs with a
+// tag never set repo_digest themselves, the module pins the digest it resolved so the rest of the module can keep
+// working with digests.
+func (dc *DockerConfig) pinTag(newConf *Config) *Config {
+	if newConf.Tag == "" || newConf.RepoDigest != "" {
+		return newConf
+	}
+	pinned := *newConf
+	if dc.conf.Tag == newConf.Tag && dc.conf.ImageName == newConf.ImageName {
+		pinned.RepoDigest = dc.conf.RepoDigest
+	}
+	return &pinned
+}
+
+// resolveTag resolves the tag of a config that hasn't been pinned to a digest yet, and applies the digest
+func (dc *DockerConfig) resolveTag(ctx context.Context, conf *Config) {
+	if _, err := dc.checkForUpdate(ctx, conf, true); err != nil && ctx.Err() == nil {
+		dc.logger.Errorf("Unable to resolve %s:%s: %v", conf.ImageName, conf.Tag, err)
+		dc.setPullFailure(fmt.Errorf("unable to resolve tag %s: %w", conf.Tag, err))
+	}
+}
+
+// checkForUpdate resolves the tag of the config and records the result as the candidate digest. If apply is true
+// and the tag points to a new digest, the component is reconfigured to use it.
+func (dc *DockerConfig) checkForUpdate(ctx context.Context, conf *Config, apply bool) (string, error) {
+	if conf.Tag == "" {
+		return "", ErrTagNotConfigured
+	}
+	digest, err := registryClientFor(conf).ResolveDigest(ctx, conf.ImageName, conf.Tag)
+
+	dc.updateMu.Lock()
+	dc.updateCheckedAt = time.Now()
+	dc.updateErr = err
+	if err == nil {
+		dc.candidateDigest = digest
+	}
+	dc.updateMu.Unlock()
+
+	if err != nil || !apply || digest == conf.RepoDigest {
+		return digest, err
+	}
+	return digest, dc.applyUpdate(conf.ImageName, conf.Tag, digest)
+}
+
+// applyUpdate pins the tag to a new digest through the normal reconfigure path
+func (dc *DockerConfig) applyUpdate(imageName string, tag string, digest string) error {
+	window := dc.currentWindow()
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	// The config may have changed while the tag was being resolved
+	if dc.conf.ImageName != imageName || dc.conf.Tag != tag || dc.conf.RepoDigest == digest {
+		return nil
+	}
+
+	dc.logger.Infof("Updating %s:%s from %q to %s", imageName, tag, dc.conf.RepoDigest, digest)
+	conf := dc.conf
+	conf.RepoDigest = digest
+	if err := dc.reconfigure(&conf, window); err != nil {
+		return err
+	}
+	dc.conf = conf
+	return nil
+}
+
+// updateDue reports whether the update watcher should check the tag now
+func (dc *DockerConfig) updateDue(conf *Config, now time.Time) bool {
+	if conf.Tag == "" {
+		return false
+	}
+	dc.updateMu.Lock()
+	checkedAt := dc.updateCheckedAt
+	dc.updateMu.Unlock()
+
+	// Until the tag has been resolved once there is nothing running, keep trying regardless of the policy
+	if conf.RepoDigest == "" {
+		return true
+	}
+	if conf.UpdatePolicy.mode() != UpdateModePoll {
+		return false
+	}
+	if now.Sub(checkedAt) < conf.UpdatePolicy.pollInterval() {
+		return false
+	}
+	open, _ := dc.maintenanceWindowOpen(conf, now)
+	return open
+}
+
+// watchUpdates applies updates deferred until the maintenance window, and checks configs with a tag for updates
+// according to their update policy
+func (dc *DockerConfig) watchUpdates() {
+	for {
+		select {
+		case <-dc.cancelCtx.Done():
+			return
+		case <-time.After(updateWatchInterval):
+		}
+
+		dc.applyPendingUpdate()
+
+		dc.mu.RLock()
+		conf := dc.conf
+		secrets := dc.secrets
+		dc.mu.RUnlock()
+		if secrets == nil || !dc.updateDue(&conf, time.Now()) {
+			continue
+		}
+		if _, err := dc.checkForUpdate(dc.cancelCtx, conf.withSecrets(secrets), true); err != nil {
+			dc.logger.Warnf("Unable to check %s:%s for updates: %v", conf.ImageName, conf.Tag, err)
+		}
+	}
+}
+
+// updateStatus returns the state of tag updates for Readings, or nil if the image isn't configured by tag
+func (dc *DockerConfig) updateStatus(conf *Config) map[string]interface{} {
+	if conf.Tag == "" {
+		return nil
+	}
+	dc.updateMu.Lock()
+	defer dc.updateMu.Unlock()
+	status := map[string]interface{}{
+		"tag":              conf.Tag,
+		"mode":             conf.UpdatePolicy.mode(),
+		"current_digest":   conf.RepoDigest,
+		"candidate_digest": dc.candidateDigest,
+		"update_available": dc.candidateDigest != "" && dc.candidateDigest != conf.RepoDigest,
+	}
+	if !dc.updateCheckedAt.IsZero() {
+		status["checked_at"] = dc.updateCheckedAt.UTC().Format(time.RFC3339)
+	}
+	if dc.updateErr != nil {
+		status["error"] = dc.updateErr.Error()
+	}
+	return status
+}
+
+// doCheckUpdate handles {"command": "check_update"}, and {"command": "apply_update"} when apply is true
+func (dc *DockerConfig) doCheckUpdate(ctx context.Context, apply bool) (map[string]interface{}, error) {
+	dc.mu.RLock()
+	conf := dc.conf
+	secrets := dc.secrets
+	dc.mu.RUnlock()
+	if conf.Tag == "" || secrets == nil {
+		return nil, ErrTagNotConfigured
+	}
+	if _, err := dc.checkForUpdate(ctx, conf.withSecrets(secrets), apply); err != nil {
+		return nil, err
+	}
+
+	dc.mu.RLock()
+	conf = dc.conf
+	dc.mu.RUnlock()
+	return dc.updateStatus(&conf), nil
+}
@@ -0,0 +1,107 @@
+package docker_deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagResolvesAndUpdates(t *testing.T) {
+	registry := newFakeRegistry(t, "user", "pass")
+	first := registry.addMultiPlatformImage("app", "stable")
+
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	conf := &Config{
+		ImageName:    registry.image("app"),
+		Tag:          "stable",
+		DownloadOnly: true,
+		UpdatePolicy: &UpdatePolicy{Mode: UpdateModePoll},
+		Credentials:  map[string]*Credentials{registry.host(): {Username: "user", Password: "pass"}},
+	}
+
+	// The first reconfigure resolves the tag and applies the digest, which pulls it
+	dc.mu.Lock()
+	assert.NoError(t, dc.reconfigure(dc.pinTag(conf), windowStatus{open: true}))
+	dc.conf = *dc.pinTag(conf)
+	dc.mu.Unlock()
+	assert.Eventually(t, func() bool {
+		exists, _ := manager.ImageExists(first)
+		return exists
+	}, 5*time.Second, 10*time.Millisecond)
+
+	dc.mu.RLock()
+	assert.Equal(t, first, dc.conf.RepoDigest)
+	current := dc.conf
+	dc.mu.RUnlock()
+	assert.False(t, dc.updateDue(&current, time.Now()))
+
+	// Moving the tag is reported as a candidate, and applied by apply_update
+	second := registry.addManifest("app", "stable", mediaTypeOCIManifest, RegistryManifest{
+		MediaType: mediaTypeOCIManifest,
+		Layers:    []RegistryDescriptor{{Digest: "sha256:c1", Size: 10}},
+	})
+	status, err := dc.DoCommand(context.Background(), map[string]interface{}{"command": "check_update"})
+	assert.NoError(t, err)
+	assert.Equal(t, second, status["candidate_digest"])
+	assert.Equal(t, first, status["current_digest"])
+	assert.Equal(t, true, status["update_available"])
+
+	readings, err := dc.Readings(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, second, readings["update"].(map[string]interface{})["candidate_digest"])
+
+	status, err = dc.DoCommand(context.Background(), map[string]interface{}{"command": "apply_update"})
+	assert.NoError(t, err)
+	assert.Equal(t, second, status["current_digest"])
+	assert.Eventually(t, func() bool {
+		exists, _ := manager.ImageExists(second)
+		return exists
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A new config for the same tag keeps the digest that was applied
+	assert.Equal(t, second, dc.pinTag(conf).RepoDigest)
+}
+
+func TestUpdateDue(t *testing.T) {
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	now := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	conf := &Config{ImageName: "ubuntu", Tag: "latest", RepoDigest: "sha256:abc"}
+	assert.False(t, dc.updateDue(conf, now))
+
+	conf.UpdatePolicy = &UpdatePolicy{Mode: UpdateModePoll, PollIntervalMinutes: 30}
+	assert.True(t, dc.updateDue(conf, now))
+
+	conf.MaintenanceWindow = &MaintenanceWindow{TimeRanges: []string{"23:00-02:00"}, Timezone: "UTC"}
+	assert.False(t, dc.updateDue(conf, now))
+	assert.True(t, dc.updateDue(conf, now.Add(-2*time.Hour)))
+
+	dc.updateCheckedAt = now.Add(-10 * time.Minute)
+	assert.False(t, dc.updateDue(conf, now.Add(-2*time.Hour+20*time.Minute)))
+
+	// A tag that was never resolved is always checked
+	conf.RepoDigest = ""
+	assert.True(t, dc.updateDue(conf, now))
+}
+
+func TestValidateTag(t *testing.T) {
+	conf := validRunConfig()
+	conf.Tag = "latest"
+	_, err := conf.Validate("")
+	assert.ErrorIs(t, err, ErrTagAndRepoDigestSet)
+
+	conf.RepoDigest = ""
+	conf.UpdatePolicy = &UpdatePolicy{Mode: "sometimes"}
+	conf.MaintenanceWindow = &MaintenanceWindow{TimeRanges: []string{"2am-4am"}, Timezone: "Mars/Olympus"}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrUnknownUpdateMode)
+	assert.ErrorIs(t, err, ErrInvalidTimeRange)
+	assert.ErrorIs(t, err, ErrInvalidTimezone)
+
+	conf.UpdatePolicy.Mode = UpdateModePoll
+	conf.MaintenanceWindow = &MaintenanceWindow{TimeRanges: []string{"02:00-04:00"}, Timezone: "America/New_York"}
+	_, err = conf.Validate("")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,198 @@
+package docker_deploy
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrVolumeRequired = errors.New("'volume' is required")
+var ErrVolumeNotUsed = errors.New("volume is not used by this component")
+var ErrBackupPathRequired = errors.New("'path' is required")
+var ErrBackupPathOutsideBackups = errors.New("'path' must be a file in the backups directory")
+var ErrVolumeOperationInProgress = errors.New("a backup or restore is already running")
+
+// Backups are written to VIAM_MODULE_DATA/backups, a directory data sync can be configured to upload
+const volumeBackupDirectory = "backups"
+
+// quiesce keeps the containers of the component from changing the volume while fn runs. Containers are paused, or
+// stopped if stop is true, and put back the way they were afterwards. The supervisors don't restart them in between.
+func (dc *DockerConfig) quiesce(stop bool, fn func() error) error {
+	dc.quiesceMu.Lock()
+	if dc.quiesced {
+		dc.quiesceMu.Unlock()
+		return ErrVolumeOperationInProgress
+	}
+	dc.quiesced = true
+	dc.quiesceMu.Unlock()
+	defer func() {
+		dc.quiesceMu.Lock()
+		dc.quiesced = false
+		dc.quiesceMu.Unlock()
+	}()
+
+	dc.mu.RLock()
+	containers := append([]DockerContainer{}, dc.containers...)
+	conf := dc.appliedConf
+	dc.mu.RUnlock()
+
+	var running []DockerContainer
+	for _, container := range containers {
+		isRunning, err := container.IsRunning()
+		if err != nil || !isRunning {
+			continue
+		}
+		if stop {
+			err = dc.stopContainer(&conf, container.GetContainerId())
+		} else {
+			err = dc.manager.PauseContainer(container.GetContainerId())
+		}
+		if err != nil {
+			dc.resume(running, stop)
+			return fmt.Errorf("unable to quiesce container %s: %w", container.GetContainerId(), err)
+		}
+		running = append(running, container)
+	}
+	defer dc.resume(running, stop)
+	return fn()
+}
+
+func (dc *DockerConfig) resume(containers []DockerContainer, stopped bool) {
+	for _, container := range containers {
+		var err error
+		if stopped {
+			err = dc.manager.StartContainer(container.GetContainerId())
+		} else {
+			err = dc.manager.UnpauseContainer(container.GetContainerId())
+		}
+		if err != nil {
+			dc.logger.Errorf("Unable to resume container %s: %v", container.GetContainerId(), err)
+		}
+	}
+}
+
+func (dc *DockerConfig) isQuiesced() bool {
+	dc.quiesceMu.Lock()
+	defer dc.quiesceMu.Unlock()
+	return dc.quiesced
+}
+
+// componentVolume checks that the volume named in the command is one of the component's named volumes
+func componentVolume(conf *Config, cmd map[string]interface{}) (string, error) {
+	name, ok := cmd["volume"].(string)
+	if !ok || name == "" {
+		return "", ErrVolumeRequired
+	}
+	volumes, err := namedVolumes(conf)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range volumes {
+		if v == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrVolumeNotUsed, name)
+}
+
+// doBackupVolume handles {"command": "backup_volume", "volume": "maps", "stop": false}
+func (dc *DockerConfig) doBackupVolume(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	// The volumes and helper image are those of the running containers, which lag dc.conf while an update is pending
+	dc.mu.RLock()
+	conf := dc.appliedConf
+	dc.mu.RUnlock()
+
+	name, err := componentVolume(&conf, cmd)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := moduleDataSubdirectory(volumeBackupDirectory)
+	if err != nil {
+		return nil, err
+	}
+	stop, _ := cmd["stop"].(bool)
+
+	var path string
+	var size int64
+	start := time.Now()
+	err = dc.quiesce(stop, func() error {
+		var err error
+		path, size, err = writeVolumeArchive(ctx, dc.manager, name, PinnedImage{ImageName: conf.ImageName, RepoDigest: conf.RepoDigest}, dir, start)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	dc.logger.Infof("Backed up volume %s to %s (%d bytes) in %s", name, path, size, time.Since(start).Round(time.Millisecond))
+	return map[string]interface{}{
+		"volume": name,
+		"path":   path,
+		"bytes":  size,
+	}, nil
+}
+
+// doRestoreVolume handles {"command": "restore_volume", "volume": "maps", "path": "maps-20240101T000000Z.tar.gz"}
+func (dc *DockerConfig) doRestoreVolume(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	// The volumes and helper image are those of the running containers, which lag dc.conf while an update is pending
+	dc.mu.RLock()
+	conf := dc.appliedConf
+	dc.mu.RUnlock()
+
+	name, err := componentVolume(&conf, cmd)
+	if err != nil {
+		return nil, err
+	}
+	path, ok := cmd["path"].(string)
+	if !ok || path == "" {
+		return nil, ErrBackupPathRequired
+	}
+	path, err = backupPath(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a gzipped tar: %w", path, err)
+	}
+
+	// Applications don't expect files to change under them, the containers are always stopped for a restore
+	err = dc.quiesce(true, func() error {
+		return dc.manager.ImportVolume(ctx, name, PinnedImage{ImageName: conf.ImageName, RepoDigest: conf.RepoDigest}, gz)
+	})
+	if err != nil {
+		return nil, err
+	}
+	dc.logger.Infof("Restored volume %s from %s", name, path)
+	return map[string]interface{}{
+		"volume": name,
+		"path":   path,
+	}, nil
+}
+
+// backupPath resolves the path of a restore against the backups directory, refusing paths outside of it. Absolute
+// paths are accepted when they are in the directory, as that is the path backup_volume returns.
+func backupPath(path string) (string, error) {
+	dir, err := moduleDataSubdirectory(volumeBackupDirectory)
+	if err != nil {
+		return "", err
+	}
+	rel := path
+	if filepath.IsAbs(path) {
+		if rel, err = filepath.Rel(dir, path); err != nil {
+			return "", err
+		}
+	}
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %s", ErrBackupPathOutsideBackups, path)
+	}
+	return filepath.Join(dir, rel), nil
+}
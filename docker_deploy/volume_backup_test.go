@@ -0,0 +1,68 @@
+package docker_deploy
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupAndRestoreVolume(t *testing.T) {
+	t.Setenv("VIAM_MODULE_DATA", t.TempDir())
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	dc.appliedConf = *validRunConfig()
+	dc.appliedConf.RunOptions.Volumes = []VolumeOptions{{Name: "maps", Target: "/data/maps"}}
+	// An update waiting for the maintenance window doesn't change what the running containers use
+	dc.conf = *validRunConfig()
+	dc.conf.RepoDigest = "sha256:pending"
+	dc.containers = []DockerContainer{&fakeDockerContainer{id: "c1"}}
+
+	backup, err := dc.DoCommand(context.Background(), map[string]interface{}{"command": "backup_volume", "volume": "maps"})
+	assert.NoError(t, err)
+	assert.Equal(t, "maps", backup["volume"])
+	assert.Greater(t, backup["bytes"], int64(0))
+	// The container is paused, not stopped, while the backup is written
+	assert.Equal(t, []string{"pause c1", "export maps", "unpause c1"}, manager.containerEvents)
+	assert.Equal(t, []PinnedImage{{ImageName: dc.appliedConf.ImageName, RepoDigest: dc.appliedConf.RepoDigest}}, manager.volumeHelpers)
+	assert.False(t, dc.isQuiesced())
+
+	manager.containerEvents = nil
+	restore, err := dc.DoCommand(context.Background(), map[string]interface{}{
+		"command": "restore_volume",
+		"volume":  "maps",
+		"path":    filepath.Base(backup["path"].(string)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, backup["path"], restore["path"])
+	assert.Equal(t, []string{"stop c1", "import maps", "start c1"}, manager.containerEvents)
+	assert.Equal(t, "maps", manager.importedVolumes["maps"])
+
+	_, err = dc.DoCommand(context.Background(), map[string]interface{}{"command": "backup_volume", "volume": "models"})
+	assert.ErrorIs(t, err, ErrVolumeNotUsed)
+	_, err = dc.DoCommand(context.Background(), map[string]interface{}{"command": "restore_volume", "volume": "maps"})
+	assert.ErrorIs(t, err, ErrBackupPathRequired)
+
+	// The path returned by backup_volume can be passed back as is, anything outside the backups directory is refused
+	_, err = dc.DoCommand(context.Background(), map[string]interface{}{"command": "restore_volume", "volume": "maps", "path": backup["path"]})
+	assert.NoError(t, err)
+	for _, path := range []string{"/etc/shadow", "../secrets.tar.gz", filepath.Join(filepath.Dir(backup["path"].(string)), "..", "x.tar.gz")} {
+		_, err = dc.DoCommand(context.Background(), map[string]interface{}{"command": "restore_volume", "volume": "maps", "path": path})
+		assert.ErrorIs(t, err, ErrBackupPathOutsideBackups, path)
+	}
+}
+
+func TestSupervisorsDontRestartQuiescedContainers(t *testing.T) {
+	dc := newTestDockerConfig(t, newFakeDockerManager())
+	s := &supervisor{container: &fakeDockerContainer{id: "c1"}}
+	assert.True(t, dc.shouldRun(s))
+	err := dc.quiesce(true, func() error {
+		assert.False(t, dc.shouldRun(s))
+		// Only one backup or restore at a time
+		assert.ErrorIs(t, dc.quiesce(false, func() error { return nil }), ErrVolumeOperationInProgress)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, dc.shouldRun(s))
+}
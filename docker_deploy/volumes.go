@@ -0,0 +1,318 @@
+package docker_deploy
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	viamutils "go.viam.com/utils"
+)
+
+var ErrVolumeNameInvalid = errors.New("volume names must start with a letter or digit and only contain letters, digits, _, . and -")
+var ErrVolumeDuplicate = errors.New("volume is listed more than once")
+var ErrVolumeTargetRequired = errors.New("volumes require an absolute target path in the container")
+var ErrUnknownOnRemove = errors.New("volume on_remove must be keep, remove or archive")
+var ErrVolumeMismatch = errors.New("a volume with the same name but a different driver already exists")
+
+// What happens to a volume once no component of the module uses it
+const (
+	VolumeOnRemoveKeep    = "keep"
+	VolumeOnRemoveRemove  = "remove"
+	VolumeOnRemoveArchive = "archive"
+)
+
+// Volumes the module created carry how to remove them and an image that can be used to archive them
+const (
+	volumeOnRemoveLabel = "com.viam.docker-manager.on_remove"
+	volumeImageLabel    = "com.viam.docker-manager.image"
+)
+
+// Components are closed whenever viam-server or the module restarts, not only when they are removed from the config.
+// A volume is only removed once no component has used it for this long.
+const volumeRemovalGracePeriod = 10 * time.Minute
+
+// How often unused volumes are checked
+const volumeSweepInterval = time.Minute
+
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// VolumeOptions declares a named volume the module creates and mounts into the container
+type VolumeOptions struct {
+	Name       string            `json:"name"`
+	Target     string            `json:"target"`
+	ReadOnly   bool              `json:"read_only"`
+	Driver     string            `json:"driver"`
+	DriverOpts map[string]string `json:"driver_opts"`
+	Labels     map[string]string `json:"labels"`
+	OnRemove   string            `json:"on_remove"`
+}
+
+func (vo *VolumeOptions) onRemove() string {
+	if vo.OnRemove == "" {
+		return VolumeOnRemoveKeep
+	}
+	return vo.OnRemove
+}
+
+func (vo *VolumeOptions) driver() string {
+	if vo.Driver == "" {
+		return "local"
+	}
+	return vo.Driver
+}
+
+func (vo *VolumeOptions) validate() []error {
+	if !volumeNamePattern.MatchString(vo.Name) {
+		return []error{fmt.Errorf("%w: %q", ErrVolumeNameInvalid, vo.Name)}
+	}
+	var validationErrors []error
+	if !filepath.IsAbs(vo.Target) {
+		validationErrors = append(validationErrors, fmt.Errorf("volume %s: %w", vo.Name, ErrVolumeTargetRequired))
+	}
+	switch vo.onRemove() {
+	case VolumeOnRemoveKeep, VolumeOnRemoveRemove, VolumeOnRemoveArchive:
+	default:
+		validationErrors = append(validationErrors, fmt.Errorf("volume %s: %w: %q", vo.Name, ErrUnknownOnRemove, vo.OnRemove))
+	}
+	return validationErrors
+}
+
+// labels returns the labels the volume is created with
+func (vo *VolumeOptions) labels(component string, image PinnedImage) map[string]string {
+	labels := map[string]string{}
+	for k, v := range vo.Labels {
+		labels[k] = v
+	}
+	labels[managedLabel] = "true"
+	labels[componentLabel] = component
+	labels[volumeOnRemoveLabel] = vo.onRemove()
+	labels[volumeImageLabel] = image.String()
+	return labels
+}
+
+func validateVolumes(conf *Config) []error {
+	if conf.RunOptions == nil {
+		return nil
+	}
+	var validationErrors []error
+	names := map[string]bool{}
+	for _, v := range conf.RunOptions.Volumes {
+		validationErrors = append(validationErrors, v.validate()...)
+		if names[v.Name] {
+			validationErrors = append(validationErrors, fmt.Errorf("%s: %w", v.Name, ErrVolumeDuplicate))
+		}
+		names[v.Name] = true
+	}
+	return validationErrors
+}
+
+func volumeNames(conf *Config) []string {
+	volumes, err := conf.volumes()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(volumes))
+	for _, v := range volumes {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// ManagedVolume is a volume the module created, as described by its labels
+type ManagedVolume struct {
+	Name      string
+	Component string
+	OnRemove  string
+	Image     PinnedImage
+}
+
+func managedVolumeFromLabels(name string, labels map[string]string) ManagedVolume {
+	imageName, repoDigest, _ := strings.Cut(labels[volumeImageLabel], "@")
+	return ManagedVolume{
+		Name:      name,
+		Component: labels[componentLabel],
+		OnRemove:  labels[volumeOnRemoveLabel],
+		Image:     PinnedImage{ImageName: imageName, RepoDigest: repoDigest},
+	}
+}
+
+// The volumes used by every component of the module
+var moduleVolumes = &volumeRegistry{byComponent: map[string][]string{}}
+
+type volumeRegistry struct {
+	mu          sync.Mutex
+	byComponent map[string][]string
+}
+
+func (vr *volumeRegistry) set(component string, names []string) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.byComponent[component] = names
+}
+
+func (vr *volumeRegistry) remove(component string) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	delete(vr.byComponent, component)
+}
+
+func (vr *volumeRegistry) contains(name string) bool {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	for _, names := range vr.byComponent {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ensureVolumes creates the volumes of the config that don't exist yet
+func (dc *DockerConfig) ensureVolumes(ctx context.Context, conf *Config) error {
+	volumes, err := conf.volumes()
+	if err != nil {
+		return err
+	}
+	image := PinnedImage{ImageName: conf.ImageName, RepoDigest: conf.RepoDigest}
+	for i := range volumes {
+		v := &volumes[i]
+		if err := dc.manager.EnsureVolume(ctx, v, v.labels(dc.Name().String(), image)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// volumeJanitor applies the on_remove policy of volumes the module created once no component uses them
+type volumeJanitor struct {
+	logger        logging.Logger
+	manager       DockerManager
+	volumes       *volumeRegistry
+	orphanedSince map[string]time.Time
+}
+
+var volumeJanitorsMu sync.Mutex
+var volumeJanitors = map[string]bool{}
+
+// startVolumeJanitor starts the janitor of a runtime, which runs for as long as the module does. The first component
+// using the runtime to start it provides the manager.
+func startVolumeJanitor(runtime string, manager DockerManager, logger logging.Logger) {
+	volumeJanitorsMu.Lock()
+	defer volumeJanitorsMu.Unlock()
+	if volumeJanitors[runtime] {
+		return
+	}
+	volumeJanitors[runtime] = true
+	vj := &volumeJanitor{logger: logger, manager: manager, volumes: moduleVolumes, orphanedSince: map[string]time.Time{}}
+	viamutils.PanicCapturingGo(func() {
+		for {
+			time.Sleep(volumeSweepInterval)
+			vj.sweep(context.Background(), time.Now())
+		}
+	})
+}
+
+// sweep removes or archives volumes that no component has used for volumeRemovalGracePeriod
+func (vj *volumeJanitor) sweep(ctx context.Context, now time.Time) {
+	volumes, err := vj.manager.ListManagedVolumes(ctx)
+	if err != nil {
+		vj.logger.Warnf("Unable to list volumes: %v", err)
+		return
+	}
+	present := map[string]bool{}
+	for _, v := range volumes {
+		present[v.Name] = true
+		if v.OnRemove != VolumeOnRemoveRemove && v.OnRemove != VolumeOnRemoveArchive {
+			continue
+		}
+		if vj.volumes.contains(v.Name) {
+			delete(vj.orphanedSince, v.Name)
+			continue
+		}
+		since, ok := vj.orphanedSince[v.Name]
+		if !ok {
+			vj.orphanedSince[v.Name] = now
+			continue
+		}
+		if now.Sub(since) < volumeRemovalGracePeriod {
+			continue
+		}
+
+		if v.OnRemove == VolumeOnRemoveArchive {
+			path, err := archiveVolume(ctx, vj.manager, v, now)
+			if err != nil {
+				vj.logger.Errorf("Unable to archive volume %s, keeping it: %v", v.Name, err)
+				continue
+			}
+			vj.logger.Infof("Archived volume %s of %s to %s", v.Name, v.Component, path)
+		}
+		if err := vj.manager.RemoveVolume(ctx, v.Name); err != nil {
+			vj.logger.Warnf("Unable to remove volume %s: %v", v.Name, err)
+			continue
+		}
+		vj.logger.Infof("Removed volume %s, no component has used it for %s", v.Name, volumeRemovalGracePeriod)
+		delete(vj.orphanedSince, v.Name)
+	}
+	for name := range vj.orphanedSince {
+		if !present[name] {
+			delete(vj.orphanedSince, name)
+		}
+	}
+}
+
+// moduleDataSubdirectory returns a directory in VIAM_MODULE_DATA, creating it if needed
+func moduleDataSubdirectory(name string) (string, error) {
+	moduleDirectory, err := moduleDataDirectory()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(moduleDirectory, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// archiveVolume writes the contents of the volume to a gzipped tar in VIAM_MODULE_DATA/volumes and returns its path
+func archiveVolume(ctx context.Context, manager DockerManager, v ManagedVolume, now time.Time) (string, error) {
+	dir, err := moduleDataSubdirectory("volumes")
+	if err != nil {
+		return "", err
+	}
+	path, _, err := writeVolumeArchive(ctx, manager, v.Name, v.Image, dir, now)
+	return path, err
+}
+
+// writeVolumeArchive streams the contents of the volume to a gzipped tar in dir, and returns its path and size
+func writeVolumeArchive(ctx context.Context, manager DockerManager, name string, helperImage PinnedImage, dir string, now time.Time) (string, int64, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.tar.gz", name, now.UTC().Format("20060102T150405Z")))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", 0, err
+	}
+	counter := &countingWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(f, counter))
+	err = manager.ExportVolume(ctx, name, helperImage, gz)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+	return path, counter.n, nil
+}
@@ -0,0 +1,99 @@
+package docker_deploy
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateVolumes(t *testing.T) {
+	conf := validRunConfig()
+	conf.RunOptions.Volumes = []VolumeOptions{
+		{Name: "maps", Target: "/data/maps", OnRemove: "archive"},
+		{Name: "models", Target: "/models", ReadOnly: true, Driver: "local", DriverOpts: map[string]string{"type": "tmpfs", "device": "tmpfs"}},
+	}
+	_, err := conf.Validate("")
+	assert.NoError(t, err)
+
+	conf.RunOptions.Volumes = []VolumeOptions{
+		{Name: "maps", Target: "data"},
+		{Name: "maps", Target: "/data", OnRemove: "delete"},
+		{Name: "/host/path", Target: "/data"},
+	}
+	_, err = conf.Validate("")
+	assert.ErrorIs(t, err, ErrVolumeTargetRequired)
+	assert.ErrorIs(t, err, ErrVolumeDuplicate)
+	assert.ErrorIs(t, err, ErrUnknownOnRemove)
+	assert.ErrorIs(t, err, ErrVolumeNameInvalid)
+}
+
+func TestEnsureVolumesLabelsOwner(t *testing.T) {
+	manager := newFakeDockerManager()
+	dc := newTestDockerConfig(t, manager)
+	conf := validRunConfig()
+	conf.RunOptions.Volumes = []VolumeOptions{{Name: "maps", Target: "/data", Labels: map[string]string{"team": "mapping"}, OnRemove: "archive"}}
+	assert.NoError(t, dc.ensureVolumes(context.Background(), conf))
+
+	labels := manager.volumes["maps"]
+	assert.Equal(t, "mapping", labels["team"])
+	assert.Equal(t, "true", labels[managedLabel])
+	assert.Equal(t, dc.Name().String(), labels[componentLabel])
+	volumes, err := manager.ListManagedVolumes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []ManagedVolume{{
+		Name:      "maps",
+		Component: dc.Name().String(),
+		OnRemove:  "archive",
+		Image:     PinnedImage{ImageName: conf.ImageName, RepoDigest: conf.RepoDigest},
+	}}, volumes)
+}
+
+func TestVolumeJanitor(t *testing.T) {
+	t.Setenv("VIAM_MODULE_DATA", t.TempDir())
+	manager := newFakeDockerManager()
+	image := PinnedImage{ImageName: "ubuntu", RepoDigest: "sha256:abc"}
+	for _, v := range []VolumeOptions{
+		{Name: "kept", OnRemove: "keep"},
+		{Name: "removed", OnRemove: "remove"},
+		{Name: "archived", OnRemove: "archive"},
+		{Name: "in-use", OnRemove: "remove"},
+	} {
+		manager.EnsureVolume(context.Background(), &v, v.labels("rdk:component:sensor/old", image))
+	}
+	// A volume created outside of the module
+	manager.volumes["external"] = map[string]string{}
+
+	registry := &volumeRegistry{byComponent: map[string][]string{"rdk:component:sensor/new": {"in-use"}}}
+	vj := &volumeJanitor{logger: newTestDockerConfig(t, manager).logger, manager: manager, volumes: registry, orphanedSince: map[string]time.Time{}}
+
+	// Components are closed on every restart, nothing is removed until the grace period is over
+	start := time.Now()
+	vj.sweep(context.Background(), start)
+	vj.sweep(context.Background(), start.Add(volumeRemovalGracePeriod-time.Second))
+	assert.Empty(t, manager.removedVolumes)
+
+	vj.sweep(context.Background(), start.Add(volumeRemovalGracePeriod))
+	assert.ElementsMatch(t, []string{"removed", "archived"}, manager.removedVolumes)
+	assert.Equal(t, []string{"archived"}, manager.exportedVolumes)
+	assert.Contains(t, manager.volumes, "kept")
+	assert.Contains(t, manager.volumes, "in-use")
+	assert.Contains(t, manager.volumes, "external")
+
+	archives, err := filepath.Glob(filepath.Join(os.Getenv("VIAM_MODULE_DATA"), "volumes", "archived-*.tar.gz"))
+	assert.NoError(t, err)
+	assert.Len(t, archives, 1)
+	f, err := os.Open(archives[0])
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	contents, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "archived", string(contents))
+}